@@ -1,8 +1,8 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import { fileService, FilterParams } from '../services/fileService';
 import { File as FileType } from '../types/file';
 import { DocumentIcon, TrashIcon, ArrowDownTrayIcon, MagnifyingGlassIcon, FunnelIcon } from '@heroicons/react/24/outline';
-import { useQuery, useMutation, useQueryClient } from '@tanstack/react-query';
+import { useInfiniteQuery, useMutation, useQueryClient } from '@tanstack/react-query';
 
 interface FilterState {
   search: string;
@@ -22,28 +22,66 @@ const initialFilterState: FilterState = {
   uploaded_before: '',
 };
 
+const THUMBNAIL_TYPES = ['image/jpeg', 'image/png', 'image/gif'];
+
+// Shows a preview of image files and a document icon for everything else.
+const FileThumbnail: React.FC<{ file: FileType }> = ({ file }) => {
+  const [url, setUrl] = useState<string | null>(null);
+  const isImage = THUMBNAIL_TYPES.includes(file.file_type);
+
+  useEffect(() => {
+    if (!isImage) return;
+    let objectUrl: string | null = null;
+    let cancelled = false;
+    fileService
+      .getThumbnail(file.id, 'small')
+      .then((u) => {
+        objectUrl = u;
+        if (cancelled) {
+          window.URL.revokeObjectURL(u);
+        } else {
+          setUrl(u);
+        }
+      })
+      .catch(() => setUrl(null));
+    return () => {
+      cancelled = true;
+      if (objectUrl) window.URL.revokeObjectURL(objectUrl);
+    };
+  }, [file.id, file.hash, isImage]);
+
+  if (url) {
+    return <img src={url} alt="" className="h-12 w-12 rounded object-cover" />;
+  }
+  return <DocumentIcon className="h-8 w-8 text-gray-400" />;
+};
+
 export const FileList: React.FC = () => {
   const queryClient = useQueryClient();
   const [inputFilters, setInputFilters] = useState<FilterState>(initialFilterState);
   const [submittedFilters, setSubmittedFilters] = useState<FilterState>(initialFilterState);
   const [showAdvanced, setShowAdvanced] = useState(false);
+  const [selected, setSelected] = useState<Set<string>>(new Set());
 
-  // Query for fetching files
-  const { data: files, isLoading, error } = useQuery({
+  // Query for fetching files, a page at a time
+  const { data, isLoading, error, fetchNextPage, hasNextPage, isFetchingNextPage } = useInfiniteQuery({
     queryKey: ['files', submittedFilters],
-    queryFn: () => {
+    queryFn: ({ pageParam }) => {
       const apiParams: FilterParams = {
         ...submittedFilters,
         size_min: submittedFilters.size_min ? parseInt(submittedFilters.size_min, 10) * 1024 : undefined,
         size_max: submittedFilters.size_max ? parseInt(submittedFilters.size_max, 10) * 1024 : undefined,
       };
-      return fileService.getFiles(apiParams);
+      return fileService.getFiles(apiParams, pageParam);
     },
+    initialPageParam: undefined as string | undefined,
+    getNextPageParam: (lastPage) => lastPage.next_cursor,
   });
+  const files = data?.pages.flatMap((page) => page.files);
 
-  // Mutation for deleting files
+  // Mutation for deleting files, all in one batch request
   const deleteMutation = useMutation({
-    mutationFn: fileService.deleteFile,
+    mutationFn: fileService.deleteFiles,
     onSuccess: () => {
       queryClient.invalidateQueries({ queryKey: ['files'] });
     },
@@ -55,14 +93,34 @@ export const FileList: React.FC = () => {
       fileService.downloadFile(fileUrl, filename),
   });
 
-  const handleDelete = async (id: string) => {
+  const handleDelete = async (ids: string[]) => {
     try {
-      await deleteMutation.mutateAsync(id);
+      const results = await deleteMutation.mutateAsync(ids);
+      results
+        .filter((result) => result.status >= 300)
+        .forEach((result) => console.error(`Delete error for ${result.id}:`, result.error));
+      setSelected((prev) => {
+        const next = new Set(prev);
+        ids.forEach((id) => next.delete(id));
+        return next;
+      });
     } catch (err) {
       console.error('Delete error:', err);
     }
   };
 
+  const toggleSelected = (id: string) => {
+    setSelected((prev) => {
+      const next = new Set(prev);
+      if (next.has(id)) {
+        next.delete(id);
+      } else {
+        next.add(id);
+      }
+      return next;
+    });
+  };
+
   const handleDownload = async (fileUrl: string, filename: string) => {
     try {
       await downloadMutation.mutateAsync({ fileUrl, filename });
@@ -138,6 +196,16 @@ export const FileList: React.FC = () => {
     <div className="p-6">
       <div className="flex justify-between items-center mb-4">
         <h2 className="text-xl font-semibold text-gray-900">File Vault</h2>
+        {selected.size > 0 && (
+          <button
+            onClick={() => handleDelete(Array.from(selected))}
+            disabled={deleteMutation.isPending}
+            className="inline-flex items-center px-3 py-2 border border-transparent shadow-sm text-sm leading-4 font-medium rounded-md text-white bg-red-600 hover:bg-red-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-red-500"
+          >
+            <TrashIcon className="h-4 w-4 mr-1" />
+            Delete selected ({selected.size})
+          </button>
+        )}
       </div>
 
       <form onSubmit={handleFilterSubmit} className="bg-gray-50 p-4 rounded-lg border border-gray-200 mb-6 space-y-4">
@@ -230,8 +298,15 @@ export const FileList: React.FC = () => {
             {files.map((file) => (
               <li key={file.id} className="py-4">
                 <div className="flex items-center space-x-4">
+                  <input
+                    type="checkbox"
+                    aria-label={`Select ${file.original_filename}`}
+                    checked={selected.has(file.id)}
+                    onChange={() => toggleSelected(file.id)}
+                    className="h-4 w-4 rounded border-gray-300 text-primary-600 focus:ring-primary-500"
+                  />
                   <div className="flex-shrink-0">
-                    <DocumentIcon className="h-8 w-8 text-gray-400" />
+                    <FileThumbnail file={file} />
                   </div>
                   <div className="flex-1 min-w-0">
                     <p className="text-sm font-medium text-gray-900 truncate">
@@ -254,7 +329,7 @@ export const FileList: React.FC = () => {
                       Download
                     </button>
                     <button
-                      onClick={() => handleDelete(file.id)}
+                      onClick={() => handleDelete([file.id])}
                       disabled={deleteMutation.isPending}
                       className="inline-flex items-center px-3 py-2 border border-transparent shadow-sm text-sm leading-4 font-medium rounded-md text-white bg-red-600 hover:bg-red-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-red-500"
                     >
@@ -266,6 +341,17 @@ export const FileList: React.FC = () => {
               </li>
             ))}
           </ul>
+          {hasNextPage && (
+            <div className="mt-6 text-center">
+              <button
+                onClick={() => fetchNextPage()}
+                disabled={isFetchingNextPage}
+                className="inline-flex items-center px-4 py-2 border border-gray-300 text-sm font-medium rounded-md shadow-sm text-gray-700 bg-white hover:bg-gray-50 disabled:text-gray-400 disabled:cursor-not-allowed"
+              >
+                {isFetchingNextPage ? 'Loading...' : 'Load more'}
+              </button>
+            </div>
+          )}
         </div>
       )}
     </div>
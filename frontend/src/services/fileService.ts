@@ -10,6 +10,119 @@ export interface FilterParams {
   size_max?: number;
   uploaded_after?: string;
   uploaded_before?: string;
+  tags_any?: string;
+  tags_all?: string;
+}
+
+export interface FileListing {
+  files: FileType[];
+  next_cursor?: string;
+  next?: string;
+  total?: number;
+}
+
+export interface BatchRequest {
+  ids: string[];
+  operation: 'delete' | 'move' | 'tag' | 'share';
+  workspace_id?: string;
+  folder_id?: string;
+  on_conflict?: 'fail' | 'rename' | 'overwrite';
+  add?: string[];
+  remove?: string[];
+}
+
+export interface BatchResult {
+  id: string;
+  status: number;
+  error?: string;
+  file_id?: string;
+}
+
+export interface BatchResponse {
+  results: BatchResult[];
+  succeeded: number;
+  failed: number;
+}
+
+export interface Job {
+  id: string;
+  operation: string;
+  status: 'running' | 'done' | 'failed';
+  total: number;
+  done: number;
+  failed: number;
+  results: BatchResult[];
+  error?: string;
+}
+
+export interface ExtractReport {
+  files: FileType[];
+  folders: { id: string; name: string; parent_id: string }[];
+  skipped: { name: string; reason: string }[];
+}
+
+export interface ArchiveListing {
+  format: 'zip' | 'tar' | 'tar.gz';
+  entries: { name: string; size: number; modified: string; dir?: boolean }[];
+  truncated?: boolean;
+}
+
+export interface DuplicateGroup {
+  hash: string;
+  count: number;
+  physical_bytes: number;
+  logical_bytes: number;
+  saved_bytes: number;
+  files: { id: string; original_filename: string; owner: string; workspace_id: string; folder_id: string; uploaded_at: string }[];
+}
+
+export interface DuplicateReport {
+  scope: 'mine' | 'all';
+  files: number;
+  duplicate_sets: number;
+  physical_bytes: number;
+  logical_bytes: number;
+  saved_bytes: number;
+  groups: DuplicateGroup[];
+}
+
+export interface PrecheckResponse {
+  uploaded: boolean;
+  file?: FileType;
+  upload_url?: string;
+}
+
+export interface UploadPolicy {
+  id: string;
+  allow_types: string[];
+  deny_types: string[];
+  allow_extensions: string[];
+  deny_extensions: string[];
+  deny_patterns: string[];
+  updated_at?: string;
+  updated_by?: string;
+}
+
+// The body of a 415 response for a file an upload policy rejects.
+export interface PolicyViolation {
+  error: string;
+  policy: string;
+  reason: 'denied_type' | 'denied_extension' | 'denied_pattern' | 'type_not_allowed' | 'extension_not_allowed';
+  rule?: string;
+  filename: string;
+  file_type: string;
+}
+
+export interface PolicyViolationReport {
+  violations: { file: FileType; violation: PolicyViolation }[];
+  truncated: boolean;
+}
+
+export interface ImageTransform {
+  w?: number;
+  h?: number;
+  fit?: 'contain' | 'cover' | 'fill';
+  format?: 'jpeg' | 'png';
 }
 
 export const fileService = {
@@ -21,14 +134,47 @@ export const fileService = {
     return response.data;
   },
 
-  async getFiles(filters: FilterParams): Promise<FileType[]> {
+  // Creates the file without sending its bytes when the server already has
+  // content with this SHA-256 that the caller may reuse. Otherwise upload
+  // the file to upload_url as usual.
+  async precheckUpload(hash: string, file: File, folderId?: string): Promise<PrecheckResponse> {
+    const response = await api.post<PrecheckResponse>(`/files/precheck/`, {
+      hash,
+      size: file.size,
+      filename: file.name,
+      content_type: file.type,
+      folder_id: folderId,
+    });
+    return response.data;
+  },
+
+  // Expands a .zip, .tar or .tar.gz archive into the target folder.
+  async uploadArchive(file: File, folderId?: string): Promise<ExtractReport> {
+    const formData = new FormData();
+    formData.append('file', file);
+    formData.append('extract', 'true');
+    if (folderId) formData.append('folder_id', folderId);
+
+    const response = await api.post(`/files/`, formData);
+    return response.data;
+  },
+
+  // The server fetches the URL in the background; poll the job for the new file's ID.
+  async importFile(url: string, folderId?: string, name?: string): Promise<Job> {
+    const response = await api.post<Job>(`/files/import/`, { url, folder_id: folderId, name });
+    return response.data;
+  },
+
+  // Returns one page; pass the listing's next_cursor to get the next one.
+  async getFiles(filters: FilterParams, cursor?: string): Promise<FileListing> {
     const params = new URLSearchParams();
     Object.entries(filters).forEach(([key, value]) => {
       if (value !== null && value !== undefined && value !== '') {
         params.append(key, String(value));
       }
     });
-    const response = await api.get(`/files/`, { params });
+    if (cursor) params.append('cursor', cursor);
+    const response = await api.get<FileListing>(`/files/`, { params });
     return response.data;
   },
 
@@ -36,9 +182,104 @@ export const fileService = {
     await api.delete(`/files/${id}/`);
   },
 
+  // Large batches are accepted as a background job to poll with getJob.
+  async batchFiles(request: BatchRequest): Promise<BatchResponse | Job> {
+    const response = await api.post(`/files/batch/`, request);
+    return response.data;
+  },
+
+  async getJob(id: string): Promise<Job> {
+    const response = await api.get<Job>(`/jobs/${id}/`);
+    return response.data;
+  },
+
+  // Moves files to the trash in one batch request, waiting for the job when
+  // the server runs it in the background. Returns the result for each file.
+  async deleteFiles(ids: string[]): Promise<BatchResult[]> {
+    let response = await fileService.batchFiles({ ids, operation: 'delete' });
+    while ('status' in response && response.status === 'running') {
+      await new Promise((resolve) => setTimeout(resolve, 1000));
+      response = await fileService.getJob(response.id);
+    }
+    if ('status' in response && response.status === 'failed') {
+      throw new Error(response.error || 'Batch delete failed');
+    }
+    return response.results;
+  },
+
+  // scope 'all' is only available to admins.
+  async getDuplicates(scope: 'mine' | 'all' = 'mine', limit?: number): Promise<DuplicateReport> {
+    const response = await api.get<DuplicateReport>(`/files/duplicates/`, { params: { scope, limit } });
+    return response.data;
+  },
+
+  // Keeps one file of a duplicate group and moves the others to the trash.
+  async collapseDuplicates(hash: string, keep?: string, scope: 'mine' | 'all' = 'mine'): Promise<{ kept: string; trashed: string[] }> {
+    const response = await api.post(`/files/duplicates/${hash}/collapse/`, { keep }, { params: { scope } });
+    return response.data;
+  },
+
+  async getUploadPolicy(workspaceId?: string): Promise<UploadPolicy> {
+    const url = workspaceId ? `/workspaces/${workspaceId}/upload-policy/` : `/upload-policy/`;
+    const response = await api.get<UploadPolicy>(url);
+    return response.data;
+  },
+
+  async setUploadPolicy(policy: Omit<UploadPolicy, 'id'>, workspaceId?: string): Promise<UploadPolicy> {
+    const url = workspaceId ? `/workspaces/${workspaceId}/upload-policy/` : `/upload-policy/`;
+    const response = await api.put<UploadPolicy>(url, policy);
+    return response.data;
+  },
+
+  // Lists stored files that break the current policies. Admins only.
+  async getPolicyViolations(workspace?: string, limit?: number): Promise<PolicyViolationReport> {
+    const response = await api.get<PolicyViolationReport>(`/upload-policy/violations/`, { params: { workspace, limit } });
+    return response.data;
+  },
+
+  // Fetches a preview of a JPEG, PNG or GIF file as an object URL. The
+  // caller revokes it with URL.revokeObjectURL.
+  async getThumbnail(id: string, size: 'small' | 'medium' | 'large' = 'medium'): Promise<string> {
+    const response = await api.get(`/files/${id}/thumbnail/`, { params: { size }, responseType: 'blob' });
+    return window.URL.createObjectURL(response.data);
+  },
+
+  // Sizes must be among the server's IMAGE_TRANSFORM_SIZES.
+  async getTransformedImage(id: string, options: ImageTransform): Promise<string> {
+    const response = await api.get(`/files/${id}/transform/`, { params: options, responseType: 'blob' });
+    return window.URL.createObjectURL(response.data);
+  },
+
+  async listArchive(id: string): Promise<ArchiveListing> {
+    const response = await api.get<ArchiveListing>(`/files/${id}/archive/`);
+    return response.data;
+  },
+
+  archiveEntryUrl(id: string, name: string): string {
+    return `/files/${id}/archive/${name.split('/').map(encodeURIComponent).join('/')}`;
+  },
+
+  // Pass file IDs for a flat archive or a folder ID for the folder tree.
+  async downloadZip(selection: { ids?: string[]; folder?: string; workspace?: string }, filename: string): Promise<void> {
+    const params = new URLSearchParams();
+    if (selection.ids) params.append('ids', selection.ids.join(','));
+    if (selection.folder) params.append('folder', selection.folder);
+    if (selection.workspace) params.append('workspace', selection.workspace);
+    const response = await api.get(`/files/zip/`, { params, responseType: 'blob' });
+
+    const url = window.URL.createObjectURL(new Blob([response.data]));
+    const link = document.createElement('a');
+    link.href = url;
+    link.download = filename;
+    document.body.appendChild(link);
+    link.click();
+    document.body.removeChild(link);
+    window.URL.revokeObjectURL(url);
+  },
+
   async downloadFile(fileUrl: string, filename: string): Promise<void> {
     try {
-      // The fileUrl from the backend is a path (e.g., /uploads/...).
+      // The fileUrl from the backend is a path (e.g., /api/files/<id>/download/).
       // We need to construct the full URL to the backend server.
       const fullUrl = `${API_ORIGIN}${fileUrl}`;
       const response = await api.get(fullUrl, {
@@ -1,9 +1,45 @@
 export interface File {
   id: string;
   original_filename: string;
+  description: string;
+  workspace_id: string;
+  folder_id: string;
+  owner: string;
+  tags: string[] | null;
+  metadata?: Record<string, string | number>;
+  score?: number;
+  snippet?: { text: string; highlights: [number, number][] };
   file_type: string;
+  declared_type?: string;
+  type_mismatch?: boolean;
   size: number;
   uploaded_at: string;
+  updated_at: string;
+  version?: number;
+  max_versions?: number;
+  deleted_at?: string;
+  deleted_by?: string;
+  source_url?: string;
+  scan?: ScanResult;
   file: string;
   hash: string | null;
-} 
\ No newline at end of file
+}
+
+export interface FileVersion {
+  version: number;
+  hash: string;
+  size: number;
+  file_type: string;
+  scan?: ScanResult;
+  uploaded_by: string;
+  uploaded_at: string;
+}
+
+// The virus scan of a file's content. Pending files are quarantined until
+// the scan is done.
+export interface ScanResult {
+  status: 'pending' | 'clean' | 'infected' | 'error';
+  threat?: string;
+  signatures?: string;
+  scanned_at?: string;
+}
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxListedEntries bounds the size of an archive listing.
+const maxListedEntries = 10000
+
+var errEntryNotFound = errors.New("entry not found")
+
+// storedArchive loads a file for the archive endpoints and returns its
+// archive format, writing an error response if it is not an archive.
+func storedArchive(ctx context.Context, w http.ResponseWriter, r *http.Request) (models.File, string, bool) {
+	file, ok := authorizeFile(ctx, w, r, chi.URLParam(r, "id"), false)
+	if !ok || !authorizeDownload(w, file.Scan) {
+		return file, "", false
+	}
+	kind := archiveKind(file.OriginalFilename)
+	if kind == "" {
+		switch file.FileType {
+		case "application/zip", "application/x-zip-compressed":
+			kind = archiveZip
+		case "application/gzip", "application/x-gzip":
+			kind = archiveTarGz
+		case "application/x-tar":
+			kind = archiveTar
+		}
+	}
+	if kind == "" {
+		http.Error(w, "File is not a ZIP, tar or tar.gz archive", http.StatusBadRequest)
+		return file, "", false
+	}
+	return file, kind, true
+}
+
+// openTar opens a stored tar or tar.gz blob for sequential reading.
+func openTar(physicalPath string, gzipped bool) (*tar.Reader, func(), error) {
+	f, err := os.Open(physicalPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !gzipped {
+		return tar.NewReader(f), func() { f.Close() }, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return tar.NewReader(gz), func() { gz.Close(); f.Close() }, nil
+}
+
+// readArchiveListing reads the table of contents of a stored archive.
+func readArchiveListing(file models.File, kind string) (models.ArchiveListing, error) {
+	listing := models.ArchiveListing{Hash: file.Hash, Format: kind, Entries: []models.ArchiveEntry{}, CreatedAt: time.Now()}
+	physicalPath := strings.TrimPrefix(file.File, "/")
+
+	if kind == archiveZip {
+		zr, err := zip.OpenReader(physicalPath)
+		if err != nil {
+			return listing, err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if len(listing.Entries) == maxListedEntries {
+				listing.Truncated = true
+				break
+			}
+			listing.Entries = append(listing.Entries, models.ArchiveEntry{
+				Name:     f.Name,
+				Size:     int64(f.UncompressedSize64),
+				Modified: f.Modified,
+				Dir:      f.Mode().IsDir(),
+			})
+		}
+		return listing, nil
+	}
+
+	tr, closeTar, err := openTar(physicalPath, kind == archiveTarGz)
+	if err != nil {
+		return listing, err
+	}
+	defer closeTar()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return listing, nil
+		}
+		if err != nil {
+			return listing, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+			continue
+		}
+		if len(listing.Entries) == maxListedEntries {
+			listing.Truncated = true
+			return listing, nil
+		}
+		listing.Entries = append(listing.Entries, models.ArchiveEntry{
+			Name:     hdr.Name,
+			Size:     hdr.Size,
+			Modified: hdr.ModTime,
+			Dir:      hdr.Typeflag == tar.TypeDir,
+		})
+	}
+}
+
+// ListArchive lists the entries of a stored ZIP, tar or tar.gz file without
+// extracting it. Listings are cached by content hash.
+func ListArchive(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	file, kind, ok := storedArchive(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	var listing models.ArchiveListing
+	err := database.ArchiveListingCollection.FindOne(ctx, bson.M{"_id": file.Hash}).Decode(&listing)
+	if err == mongo.ErrNoDocuments {
+		listing, err = readArchiveListing(file, kind)
+		if err != nil {
+			http.Error(w, "Could not read the archive", http.StatusUnprocessableEntity)
+			log.Printf("Error listing archive %s: %v", file.ID, err)
+			return
+		}
+		// A failed cache write only costs a re-read next time.
+		if _, err := database.ArchiveListingCollection.InsertOne(ctx, listing); err != nil && !mongo.IsDuplicateKeyError(err) {
+			log.Printf("Error caching listing of archive %s: %v", file.Hash, err)
+		}
+	} else if err != nil {
+		http.Error(w, "Failed to fetch archive listing from database", http.StatusInternalServerError)
+		log.Printf("Error fetching listing of archive %s: %v", file.Hash, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listing)
+}
+
+// GetArchiveEntry streams a single entry out of a stored archive. The entry
+// is addressed by its name as listed by ListArchive. ZIP entries are read
+// directly; tar archives are scanned up to the entry.
+func GetArchiveEntry(w http.ResponseWriter, r *http.Request) {
+	name, err := url.PathUnescape(chi.URLParam(r, "*"))
+	if err != nil || name == "" {
+		http.Error(w, "Invalid entry name", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	file, kind, ok := storedArchive(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	physicalPath := strings.TrimPrefix(file.File, "/")
+	serve := func(content io.Reader, size int64, modified time.Time) {
+		if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		if !modified.IsZero() {
+			w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		}
+		if _, err := io.Copy(w, content); err != nil {
+			log.Printf("Error streaming %s from archive %s: %v", name, file.ID, err)
+		}
+	}
+
+	if kind == archiveZip {
+		err = func() error {
+			zr, err := zip.OpenReader(physicalPath)
+			if err != nil {
+				return err
+			}
+			defer zr.Close()
+			for _, f := range zr.File {
+				if f.Name != name || f.Mode().IsDir() {
+					continue
+				}
+				rc, err := f.Open()
+				if err != nil {
+					return err
+				}
+				defer rc.Close()
+				serve(rc, int64(f.UncompressedSize64), f.Modified)
+				return nil
+			}
+			return errEntryNotFound
+		}()
+	} else {
+		err = func() error {
+			tr, closeTar, err := openTar(physicalPath, kind == archiveTarGz)
+			if err != nil {
+				return err
+			}
+			defer closeTar()
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					return errEntryNotFound
+				}
+				if err != nil {
+					return err
+				}
+				if hdr.Typeflag == tar.TypeReg && hdr.Name == name {
+					serve(tr, hdr.Size, hdr.ModTime)
+					return nil
+				}
+			}
+		}()
+	}
+
+	if err == errEntryNotFound {
+		http.Error(w, "Entry not found in archive", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not read the archive", http.StatusUnprocessableEntity)
+		log.Printf("Error reading archive %s: %v", file.ID, err)
+	}
+}
@@ -0,0 +1,433 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"file-hub-go/audit"
+	"file-hub-go/config"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Archive formats that can be extracted on upload.
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+// archiveKind returns the format of an archive by its file name, or "" if
+// it is not a supported archive.
+func archiveKind(filename string) string {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar
+	}
+	return ""
+}
+
+// archiveEntryPath splits an entry name into its path components. Names
+// that would land outside the target folder (absolute paths, drive letters,
+// "..") are rejected so that an archive cannot write anywhere else.
+func archiveEntryPath(name string) ([]string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return nil, errors.New("absolute paths are not allowed")
+	}
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return nil, errors.New("path leaves the target folder")
+		}
+		if err := validateName("name", part); err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("empty name")
+	}
+	return parts, nil
+}
+
+// SkippedEntry is an archive entry that was not extracted, and why.
+type SkippedEntry struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// ExtractReport is the response to an upload with extract=true.
+type ExtractReport struct {
+	Files   []models.File   `json:"files"`
+	Folders []models.Folder `json:"folders"` // Folders created for the archive's directories
+	Skipped []SkippedEntry  `json:"skipped"`
+}
+
+// archiveExtractor turns archive entries into files below a target folder.
+type archiveExtractor struct {
+	username    string
+	workspaceID string
+	folderID    string
+	depth       int // Depth of the target folder
+	metadata    map[string]interface{}
+	archive     string
+	budget      int64 // Bytes left before the expansion ratio is exceeded
+	entries     int
+	stopped     string            // Set once extraction must stop, with the reason
+	folders     map[string]string // Directory path in the archive to folder ID
+	report      ExtractReport
+}
+
+// extractUpload expands an uploaded archive into the given folder. Entries
+// are checked one by one and the ones that cannot be extracted are listed in
+// the report rather than failing the whole upload.
+func extractUpload(w http.ResponseWriter, r *http.Request, file multipart.File, handler *multipart.FileHeader, workspaceID, folderID string, metadata map[string]interface{}) {
+	kind := archiveKind(handler.Filename)
+	if kind == "" {
+		http.Error(w, "extract is only supported for .zip, .tar, .tar.gz and .tgz files", http.StatusBadRequest)
+		return
+	}
+
+	// Extraction writes many files, so it gets more time than an upload.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	breadcrumbs, err := folderBreadcrumbs(ctx, folderID)
+	if err != nil {
+		http.Error(w, "Failed to fetch folder from database", http.StatusInternalServerError)
+		log.Printf("Error fetching breadcrumbs for folder %s: %v", folderID, err)
+		return
+	}
+
+	x := &archiveExtractor{
+		username:    currentUser(r),
+		workspaceID: workspaceID,
+		folderID:    folderID,
+		depth:       len(breadcrumbs),
+		metadata:    metadata,
+		archive:     handler.Filename,
+		budget:      handler.Size * config.AppConfig.ArchiveMaxRatio,
+		folders:     map[string]string{},
+		report:      ExtractReport{Files: []models.File{}, Folders: []models.Folder{}, Skipped: []SkippedEntry{}},
+	}
+
+	switch kind {
+	case archiveZip:
+		err = x.extractZip(ctx, file, handler.Size)
+	default:
+		err = x.extractTar(ctx, file, kind == archiveTarGz)
+	}
+	var invalid invalidArchiveError
+	if errors.As(err, &invalid) {
+		http.Error(w, invalid.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		// Files extracted so far stay in place; report what was done.
+		log.Printf("Error extracting %s: %v", handler.Filename, err)
+		x.skip("", "extraction stopped because of a server error")
+	}
+	audit.Log(x.username, "file.extract", handler.Filename, map[string]string{
+		"workspace_id": workspaceID,
+		"folder_id":    folderID,
+		"files":        fmt.Sprint(len(x.report.Files)),
+		"skipped":      fmt.Sprint(len(x.report.Skipped)),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(x.report)
+}
+
+// invalidArchiveError is an archive that cannot be read or is rejected as a
+// whole.
+type invalidArchiveError struct{ msg string }
+
+func (e invalidArchiveError) Error() string { return e.msg }
+
+func (x *archiveExtractor) extractZip(ctx context.Context, file multipart.File, size int64) error {
+	zr, err := zip.NewReader(file, size)
+	if err != nil {
+		return invalidArchiveError{"Could not read the ZIP archive"}
+	}
+	// The central directory lists every entry up front, so oversized
+	// archives are rejected before anything is written.
+	if len(zr.File) > config.AppConfig.ArchiveMaxEntries {
+		return invalidArchiveError{fmt.Sprintf("The archive has more than %d entries", config.AppConfig.ArchiveMaxEntries)}
+	}
+	var declared uint64
+	for _, f := range zr.File {
+		declared += f.UncompressedSize64
+	}
+	if declared > uint64(x.budget) {
+		return invalidArchiveError{"The archive expands to more than the allowed size"}
+	}
+
+	for _, f := range zr.File {
+		if f.Mode().IsDir() {
+			x.addDir(ctx, f.Name)
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			x.skip(f.Name, "not a regular file")
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			x.skip(f.Name, "could not be read")
+			continue
+		}
+		err = x.addFile(ctx, f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *archiveExtractor) extractTar(ctx context.Context, file multipart.File, gzipped bool) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var src io.Reader = file
+	if gzipped {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return invalidArchiveError{"Could not read the gzip archive"}
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if len(x.report.Files) == 0 && x.entries == 0 {
+				return invalidArchiveError{"Could not read the tar archive"}
+			}
+			x.skip("", "the rest of the archive could not be read")
+			return nil
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			x.addDir(ctx, hdr.Name)
+		case tar.TypeReg:
+			if err := x.addFile(ctx, hdr.Name, tr); err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+		default:
+			x.skip(hdr.Name, "not a regular file")
+		}
+		if x.stopped != "" {
+			return nil
+		}
+	}
+}
+
+// skip records an entry that was not extracted.
+func (x *archiveExtractor) skip(name, reason string) {
+	x.report.Skipped = append(x.report.Skipped, SkippedEntry{Name: name, Reason: reason})
+}
+
+// count counts an entry against the entry limit and reports whether it may
+// still be extracted.
+func (x *archiveExtractor) count(name string) bool {
+	if x.stopped != "" {
+		x.skip(name, x.stopped)
+		return false
+	}
+	x.entries++
+	if x.entries > config.AppConfig.ArchiveMaxEntries {
+		x.stopped = fmt.Sprintf("the archive has more than %d entries", config.AppConfig.ArchiveMaxEntries)
+		x.skip(name, x.stopped)
+		return false
+	}
+	return true
+}
+
+func (x *archiveExtractor) addDir(ctx context.Context, name string) {
+	if !x.count(name) {
+		return
+	}
+	parts, err := archiveEntryPath(name)
+	if err != nil {
+		x.skip(name, err.Error())
+		return
+	}
+	if _, err := x.ensureFolders(ctx, parts); err != nil {
+		x.skip(name, err.Error())
+	}
+}
+
+// addFile extracts one regular file. Only server-side failures are
+// returned; problems with the entry itself are recorded as skipped.
+func (x *archiveExtractor) addFile(ctx context.Context, name string, r io.Reader) error {
+	if !x.count(name) {
+		return nil
+	}
+	parts, err := archiveEntryPath(name)
+	if err != nil {
+		x.skip(name, err.Error())
+		return nil
+	}
+	filename := parts[len(parts)-1]
+	if parts[0] == "__MACOSX" || filename == ".DS_Store" {
+		x.skip(name, "macOS metadata")
+		return nil
+	}
+	folderID, err := x.ensureFolders(ctx, parts[:len(parts)-1])
+	if err != nil {
+		x.skip(name, err.Error())
+		return nil
+	}
+
+	// Entry sizes in headers cannot be trusted, so count the bytes as they
+	// are written. One byte over a limit is enough to know it is exceeded.
+	tmp, err := os.CreateTemp("", "filehub-extract-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	limit := min(config.AppConfig.MaxUploadSize, x.budget)
+	size, err := io.Copy(tmp, io.LimitReader(r, limit+1))
+	if err != nil {
+		x.skip(name, "could not be read")
+		return nil
+	}
+	if size > x.budget {
+		x.stopped = "the archive expands to more than the allowed size"
+		x.skip(name, x.stopped)
+		return nil
+	}
+	if size > config.AppConfig.MaxUploadSize {
+		x.skip(name, fmt.Sprintf("larger than the %dMB upload limit", config.AppConfig.MaxUploadSize/1024/1024))
+		return nil
+	}
+	x.budget -= size
+
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	fileType, _, _ := detectType(tmp, filename, contentType)
+	violation, err := checkUploadPolicy(ctx, x.workspaceID, filename, fileType)
+	if err != nil {
+		return err
+	}
+	if violation != nil {
+		x.skip(name, violation.Message)
+		return nil
+	}
+	blob, err := storeBlob(ctx, tmp, size, filename, contentType, blobOwner{x.username, x.workspaceID})
+	var infected infectedError
+	if errors.As(err, &infected) {
+		x.skip(name, infected.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	filename, _, err = resolveNameConflict(ctx, x.workspaceID, folderID, filename, "", conflictRename)
+	if err == errNameConflict {
+		x.skip(name, err.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	newFile := newUploadedFile(blob, filename, x.username)
+	newFile.WorkspaceID = x.workspaceID
+	newFile.FolderID = folderID
+	newFile.Metadata = x.metadata
+	if _, err := database.FileCollection.InsertOne(ctx, newFile); err != nil {
+		return err
+	}
+	queueScan()
+	queueThumbnails(blob)
+	audit.Log(x.username, "file.upload", newFile.ID, map[string]string{
+		"filename": newFile.OriginalFilename,
+		"hash":     newFile.Hash,
+		"archive":  x.archive,
+	})
+	newFile.Content = ""
+	x.report.Files = append(x.report.Files, newFile)
+	return nil
+}
+
+// ensureFolders returns the folder for a directory path in the archive,
+// reusing existing folders of the same name and creating missing ones.
+func (x *archiveExtractor) ensureFolders(ctx context.Context, parts []string) (string, error) {
+	if x.depth+len(parts) > maxFolderDepth {
+		return "", errors.New("nested too deeply")
+	}
+	parentID := x.folderID
+	for i := range parts {
+		key := path.Join(parts[:i+1]...)
+		if id, ok := x.folders[key]; ok {
+			parentID = id
+			continue
+		}
+
+		var folder models.Folder
+		filter := bson.M{"workspace_id": emptyOr(x.workspaceID), "parent_id": folderIDValue(parentID), "name": parts[i]}
+		err := database.FolderCollection.FindOne(ctx, filter).Decode(&folder)
+		if err == mongo.ErrNoDocuments {
+			now := time.Now()
+			folder = models.Folder{
+				ID:          uuid.New().String(),
+				Name:        parts[i],
+				ParentID:    parentID,
+				WorkspaceID: x.workspaceID,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			}
+			if _, err = database.FolderCollection.InsertOne(ctx, folder); err == nil {
+				x.report.Folders = append(x.report.Folders, folder)
+				audit.Log(x.username, "folder.create", folder.ID, map[string]string{"name": folder.Name, "parent_id": folder.ParentID})
+			} else if mongo.IsDuplicateKeyError(err) {
+				// Created concurrently; use that one.
+				err = database.FolderCollection.FindOne(ctx, filter).Decode(&folder)
+			}
+		}
+		if err != nil {
+			log.Printf("Error creating folder %s from archive: %v", key, err)
+			return "", errors.New("its folder could not be created")
+		}
+		x.folders[key] = folder.ID
+		parentID = folder.ID
+	}
+	return parentID, nil
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"file-hub-go/config"
+	"file-hub-go/models"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// archiveFile is an in-memory archive that passes for an uploaded file.
+type archiveFile struct{ *bytes.Reader }
+
+func (archiveFile) Close() error { return nil }
+
+// setArchiveConfig sets the extraction limits for a test.
+func setArchiveConfig(t *testing.T, maxEntries int, maxRatio int64) {
+	t.Helper()
+	saved := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = saved })
+	config.AppConfig.MaxUploadSize = 10 << 20
+	config.AppConfig.ArchiveMaxEntries = maxEntries
+	config.AppConfig.ArchiveMaxRatio = maxRatio
+}
+
+// newTestExtractor returns an extractor for an archive of size bytes set up
+// the way extractUpload does it.
+func newTestExtractor(size int64) *archiveExtractor {
+	return &archiveExtractor{
+		archive: "test",
+		budget:  size * config.AppConfig.ArchiveMaxRatio,
+		folders: map[string]string{},
+		report:  ExtractReport{Files: []models.File{}, Folders: []models.Folder{}, Skipped: []SkippedEntry{}},
+	}
+}
+
+// archiveEntry is a regular file to put in a test archive.
+type archiveEntry struct {
+	name string
+	data []byte
+}
+
+// tarGz builds a gzipped tar archive with the given entries in order.
+func tarGz(t *testing.T, entries ...archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Mode: 0o644, Size: int64(len(entry.data)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// zipArchive builds a ZIP archive with the given entries in order.
+func zipArchive(t *testing.T, entries ...archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := zw.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestArchiveEntryPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    []string
+		wantErr string
+	}{
+		{name: "a/b.txt", want: []string{"a", "b.txt"}},
+		{name: "./a//b.txt", want: []string{"a", "b.txt"}},
+		{name: `dir\file.txt`, want: []string{"dir", "file.txt"}},
+		{name: "a/", want: []string{"a"}},
+		{name: "../etc/passwd", wantErr: "path leaves the target folder"},
+		{name: "a/../../b", wantErr: "path leaves the target folder"},
+		{name: "a/..", wantErr: "path leaves the target folder"},
+		{name: `..\..\boot.ini`, wantErr: "path leaves the target folder"},
+		{name: "/etc/passwd", wantErr: "absolute paths are not allowed"},
+		{name: `\\server\share\x`, wantErr: "absolute paths are not allowed"},
+		{name: "C:/Windows/win.ini", wantErr: "absolute paths are not allowed"},
+		{name: `c:\x.txt`, wantErr: "absolute paths are not allowed"},
+		{name: "C:x.txt", wantErr: "absolute paths are not allowed"},
+		{name: "", wantErr: "empty name"},
+		{name: "./", wantErr: "empty name"},
+	}
+	for _, test := range tests {
+		parts, err := archiveEntryPath(test.name)
+		if test.wantErr != "" {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("archiveEntryPath(%q) = %v, %v, want error %q", test.name, parts, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(parts, test.want) {
+			t.Errorf("archiveEntryPath(%q) = %v, %v, want %v", test.name, parts, err, test.want)
+		}
+	}
+}
+
+func TestExtractTarStopsAtRatio(t *testing.T) {
+	setArchiveConfig(t, 100, 10)
+	// A megabyte of zeros compresses to about a kilobyte.
+	data := tarGz(t,
+		archiveEntry{"bomb.bin", make([]byte, 1<<20)},
+		archiveEntry{"after.bin", make([]byte, 1<<20)},
+	)
+	x := newTestExtractor(int64(len(data)))
+	if err := x.extractTar(context.Background(), archiveFile{bytes.NewReader(data)}, true); err != nil {
+		t.Fatalf("extractTar: %v", err)
+	}
+	want := []SkippedEntry{{Name: "bomb.bin", Reason: "the archive expands to more than the allowed size"}}
+	if !reflect.DeepEqual(x.report.Skipped, want) {
+		t.Errorf("skipped = %v, want %v", x.report.Skipped, want)
+	}
+	if len(x.report.Files) != 0 {
+		t.Errorf("extracted %d files, want none", len(x.report.Files))
+	}
+}
+
+func TestExtractTarStopsAtEntryLimit(t *testing.T) {
+	setArchiveConfig(t, 3, 100)
+	var entries []archiveEntry
+	for i := range 10 {
+		entries = append(entries, archiveEntry{fmt.Sprintf("__MACOSX/._%d", i), nil})
+	}
+	data := tarGz(t, entries...)
+	x := newTestExtractor(int64(len(data)))
+	if err := x.extractTar(context.Background(), archiveFile{bytes.NewReader(data)}, true); err != nil {
+		t.Fatalf("extractTar: %v", err)
+	}
+	// Three entries are processed, the fourth stops the extraction and the
+	// rest are never read.
+	if len(x.report.Skipped) != 4 {
+		t.Fatalf("skipped = %v, want 4 entries", x.report.Skipped)
+	}
+	last := x.report.Skipped[3]
+	if want := (SkippedEntry{Name: "__MACOSX/._3", Reason: "the archive has more than 3 entries"}); last != want {
+		t.Errorf("last skipped = %v, want %v", last, want)
+	}
+}
+
+func TestExtractZipRejectsBombs(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		want    string
+	}{
+		{
+			name:    "ratio",
+			entries: []archiveEntry{{"bomb.bin", make([]byte, 1<<20)}},
+			want:    "The archive expands to more than the allowed size",
+		},
+		{
+			name:    "entries",
+			entries: []archiveEntry{{"a", nil}, {"b", nil}, {"c", nil}, {"d", nil}},
+			want:    "The archive has more than 3 entries",
+		},
+	}
+	setArchiveConfig(t, 3, 10)
+	for _, test := range tests {
+		data := zipArchive(t, test.entries...)
+		x := newTestExtractor(int64(len(data)))
+		err := x.extractZip(context.Background(), archiveFile{bytes.NewReader(data)}, int64(len(data)))
+		var invalid invalidArchiveError
+		if !errors.As(err, &invalid) || invalid.Error() != test.want {
+			t.Errorf("%s: extractZip = %v, want %q", test.name, err, test.want)
+		}
+		if len(x.report.Files) != 0 || len(x.report.Skipped) != 0 {
+			t.Errorf("%s: report = %+v, want nothing extracted", test.name, x.report)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"file-hub-go/audit"
 	"file-hub-go/config"
 	"file-hub-go/database"
 	"file-hub-go/models"
@@ -24,6 +25,12 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// currentUser returns the username that the JWT middleware stored on the request.
+func currentUser(r *http.Request) string {
+	username, _ := r.Context().Value("username").(string)
+	return username
+}
+
 // RegisterUser handles new user registration.
 func RegisterUser(w http.ResponseWriter, r *http.Request) {
 	var creds Credentials
@@ -59,6 +66,7 @@ func RegisterUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	audit.Log(newUser.Username, "user.register", newUser.ID, nil)
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -82,6 +90,7 @@ func LoginUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(creds.Password)); err != nil {
+		audit.Log(creds.Username, "user.login_failed", user.ID, nil)
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
@@ -102,6 +111,7 @@ func LoginUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Could not generate token", http.StatusInternalServerError)
 		return
 	}
+	audit.Log(user.Username, "user.login", user.ID, nil)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
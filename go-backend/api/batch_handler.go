@@ -0,0 +1,277 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"file-hub-go/audit"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	maxBatchItems = 10000
+	// batchChunkSize is how many items are applied at a time. Larger
+	// batches run as a background job.
+	batchChunkSize = 100
+)
+
+// Batch operations.
+const (
+	batchDelete = "delete"
+	batchMove   = "move"
+	batchTag    = "tag"
+	batchShare  = "share"
+)
+
+// BatchRequest is the body of the batch endpoint. Move and share place the
+// files in WorkspaceID and FolderID; share leaves the originals in place and
+// adds a copy for the members of the destination workspace.
+type BatchRequest struct {
+	IDs         []string `json:"ids"`
+	Operation   string   `json:"operation"` // delete, move, tag or share
+	WorkspaceID string   `json:"workspace_id"`
+	FolderID    string   `json:"folder_id"`
+	OnConflict  string   `json:"on_conflict"`
+	Add         []string `json:"add"` // Tags to add for tag
+	Remove      []string `json:"remove"`
+}
+
+// BatchResponse holds the per-file results of a batch that ran immediately.
+type BatchResponse struct {
+	Results   []models.JobResult `json:"results"`
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+}
+
+// batch applies one operation to files chunk by chunk. Roles are cached
+// across chunks so every workspace is only looked up once.
+type batch struct {
+	username string
+	req      BatchRequest
+	roles    map[string]string
+}
+
+// BatchFiles applies an operation to many files. Every file succeeds or
+// fails on its own and gets its own result. Deletes and tag changes of the
+// files that pass their checks are applied in a transaction per chunk when
+// the database supports it. Batches larger than one chunk run in the
+// background; the response is then 202 Accepted with a job to poll.
+func BatchFiles(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Drop duplicate IDs so each file has exactly one result.
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, id := range req.IDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 || len(ids) > maxBatchItems {
+		http.Error(w, fmt.Sprintf("ids must contain between 1 and %d IDs", maxBatchItems), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	switch req.Operation {
+	case batchDelete:
+	case batchTag:
+		var err error
+		req.Add, err = normalizeTags(req.Add)
+		if err == nil {
+			req.Remove, err = normalizeTags(req.Remove)
+		}
+		if err == nil {
+			err = checkTagChange(req.Add, req.Remove)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Add) == 0 && len(req.Remove) == 0 {
+			http.Error(w, "No tags provided", http.StatusBadRequest)
+			return
+		}
+	case batchMove, batchShare:
+		transfer := TransferRequest{OnConflict: req.OnConflict}
+		if err := validateOnConflict(&transfer); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.OnConflict = transfer.OnConflict
+		if !authorizeWorkspace(ctx, w, r, req.WorkspaceID, true) {
+			return
+		}
+		if exists, err := folderInWorkspace(ctx, req.FolderID, req.WorkspaceID); err != nil {
+			http.Error(w, "Failed to fetch folder from database", http.StatusInternalServerError)
+			return
+		} else if !exists {
+			http.Error(w, "Folder not found", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "operation must be delete, move, tag or share", http.StatusBadRequest)
+		return
+	}
+
+	b := &batch{username: currentUser(r), req: req, roles: map[string]string{}}
+
+	if len(ids) > batchChunkSize {
+		job, err := startJob(b.username, "batch."+req.Operation, len(ids), func(ctx context.Context, report func([]models.JobResult)) error {
+			for start := 0; start < len(ids); start += batchChunkSize {
+				end := min(start+batchChunkSize, len(ids))
+				results, err := b.apply(ctx, ids[start:end])
+				if err != nil {
+					return err
+				}
+				report(results)
+			}
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Could not start batch job", http.StatusInternalServerError)
+			log.Printf("Error creating batch job: %v", err)
+			return
+		}
+		writeJobAccepted(w, job)
+		return
+	}
+
+	results, err := b.apply(ctx, ids)
+	if err != nil {
+		http.Error(w, "Failed to fetch files from database", http.StatusInternalServerError)
+		log.Printf("Error applying batch %s: %v", req.Operation, err)
+		return
+	}
+	response := BatchResponse{Results: results}
+	for _, result := range results {
+		if result.Status >= http.StatusBadRequest {
+			response.Failed++
+		} else {
+			response.Succeeded++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// apply runs the operation on one chunk of IDs and returns a result for
+// each, in the same order. An error means the chunk could not be processed
+// at all.
+func (b *batch) apply(ctx context.Context, ids []string) ([]models.JobResult, error) {
+	var files []models.File
+	cursor, err := database.FileCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "deleted_at": nil})
+	if err == nil {
+		err = cursor.All(ctx, &files)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := map[string]models.JobResult{}
+	for _, id := range ids {
+		results[id] = models.JobResult{ID: id, Status: http.StatusNotFound, Error: "File not found"}
+	}
+
+	// Sharing only reads the originals; everything else changes them.
+	write := b.req.Operation != batchShare
+	var allowed []models.File
+	for _, file := range files {
+		role, ok := b.roles[file.WorkspaceID]
+		if !ok {
+			if role, err = workspaceRole(ctx, b.username, file.WorkspaceID); err != nil {
+				return nil, err
+			}
+			b.roles[file.WorkspaceID] = role
+		}
+		switch {
+		case role == "":
+			// Leave the "File not found" result.
+		case write && !canWrite(role):
+			results[file.ID] = models.JobResult{ID: file.ID, Status: http.StatusForbidden, Error: "You do not have write access to this file"}
+		case b.req.Operation == batchTag && tooManyTags(file.Tags, b.req.Add):
+			results[file.ID] = models.JobResult{ID: file.ID, Status: http.StatusBadRequest, Error: fmt.Sprintf("a file can have at most %d tags", maxTagsPerFile)}
+		default:
+			allowed = append(allowed, file)
+		}
+	}
+
+	switch b.req.Operation {
+	case batchDelete, batchTag:
+		b.applyUpdate(ctx, allowed, results)
+	case batchMove, batchShare:
+		transfer := TransferRequest{WorkspaceID: b.req.WorkspaceID, FolderID: b.req.FolderID, OnConflict: b.req.OnConflict}
+		for _, file := range allowed {
+			placed, status, err := placeFile(ctx, b.username, file, transfer, b.req.Operation == batchMove)
+			result := models.JobResult{ID: file.ID, Status: status}
+			if err != nil {
+				result.Error = err.Error()
+			} else if b.req.Operation == batchShare {
+				result.FileID = placed.ID
+			}
+			results[file.ID] = result
+		}
+	}
+
+	ordered := make([]models.JobResult, 0, len(ids))
+	for _, id := range ids {
+		ordered = append(ordered, results[id])
+	}
+	return ordered, nil
+}
+
+// applyUpdate deletes or tags the allowed files with one update, in a
+// transaction when the database supports it, so they all change or none do.
+func (b *batch) applyUpdate(ctx context.Context, allowed []models.File, results map[string]models.JobResult) {
+	if len(allowed) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(allowed))
+	for _, file := range allowed {
+		ids = append(ids, file.ID)
+	}
+
+	err := database.WithTransaction(ctx, func(ctx context.Context) error {
+		if b.req.Operation == batchDelete {
+			return trashFiles(ctx, b.username, bson.M{"_id": bson.M{"$in": ids}})
+		}
+		return updateTags(ctx, bson.M{"_id": bson.M{"$in": ids}, "deleted_at": nil}, b.req.Add, b.req.Remove)
+	})
+	if err != nil {
+		log.Printf("Error applying batch %s: %v", b.req.Operation, err)
+		for _, id := range ids {
+			results[id] = models.JobResult{ID: id, Status: http.StatusInternalServerError, Error: "Failed to update file"}
+		}
+		return
+	}
+
+	for _, file := range allowed {
+		results[file.ID] = models.JobResult{ID: file.ID, Status: http.StatusOK}
+		if b.req.Operation == batchDelete {
+			audit.Log(b.username, "file.delete", file.ID, map[string]string{
+				"filename": file.OriginalFilename,
+				"hash":     file.Hash,
+			})
+		} else {
+			audit.Log(b.username, "file.tag", file.ID, map[string]string{
+				"add":    strings.Join(b.req.Add, ","),
+				"remove": strings.Join(b.req.Remove, ","),
+			})
+		}
+	}
+}
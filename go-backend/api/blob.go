@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"file-hub-go/config"
+	"file-hub-go/database"
+	"file-hub-go/extract"
+	"file-hub-go/models"
+	"file-hub-go/sniff"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// blobSource is uploaded content that can be hashed, parsed and copied.
+// Multipart uploads and temporary files both satisfy it.
+type blobSource interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// storedBlob is content saved in the upload directory.
+type storedBlob struct {
+	Path    string // URL path of the physical file, e.g. /uploads/<uuid>.pdf
+	Hash    string
+	Size    int64
+	Content string // Extracted text for content search
+
+	FileType     string // Detected from the content, or the declared type if unknown
+	DeclaredType string
+	TypeMismatch bool
+
+	Scan *models.ScanResult // nil when scanning is off
+}
+
+// blobOwner is who content is stored for. Together with DEDUP_SCOPE it
+// decides which stored content an upload may be deduplicated against.
+type blobOwner struct {
+	Username    string
+	WorkspaceID string
+}
+
+// storeBlob hashes and saves content. If content with the same hash is
+// already stored within the deduplication scope, by a file or a version,
+// the existing physical file is reused instead of writing a second copy.
+// Content found infected by an inline scan is not saved and an
+// infectedError is returned.
+func storeBlob(ctx context.Context, src blobSource, size int64, filename, contentType string, owner blobOwner) (storedBlob, error) {
+	blob := storedBlob{Size: size}
+
+	// Calculate the file hash for deduplication
+	hash, err := calculateFileHash(src)
+	if err != nil {
+		return blob, fmt.Errorf("could not calculate file hash: %w", err)
+	}
+	blob.Hash = hash
+	blob.FileType, blob.DeclaredType, blob.TypeMismatch = detectType(src, filename, contentType)
+	blob.Content = extractContent(src, size, filename, blob.FileType)
+	if blob.Scan, err = scanUpload(ctx, src, size); err != nil {
+		return blob, err
+	}
+
+	path, err := findBlob(ctx, hash, owner)
+	if err != nil {
+		return blob, err
+	}
+	if path != "" {
+		blob.Path = path
+		return blob, nil
+	}
+
+	// NEW CONTENT: Save the physical file.
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return blob, err
+	}
+	newFilename := uuid.New().String() + filepath.Ext(filename)
+	filePath := filepath.Join(config.AppConfig.UploadDir, newFilename)
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return blob, err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(filePath)
+		return blob, err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(filePath)
+		return blob, err
+	}
+	blob.Path = "/" + filePath // Store the URL path
+	return blob, nil
+}
+
+// dedupFilter matches the files whose current content or one of whose
+// versions has the given hash and may be shared with owner's uploads. It
+// returns nil when deduplication is off.
+func dedupFilter(hash string, owner blobOwner) bson.M {
+	current := bson.M{"hash": hash}
+	version := bson.M{"hash": hash}
+	switch config.AppConfig.DedupScope {
+	case config.DedupOff:
+		return nil
+	case config.DedupTenant:
+		// Versions always belong to the workspace of their file.
+		return bson.M{"workspace_id": emptyOr(owner.WorkspaceID), "$or": bson.A{current, bson.M{"versions.hash": hash}}}
+	case config.DedupUser:
+		current["owner"] = owner.Username
+		version["uploaded_by"] = owner.Username
+	}
+	return bson.M{"$or": bson.A{current, bson.M{"versions": bson.M{"$elemMatch": version}}}}
+}
+
+// blobPath returns the physical file of the content with the given hash in
+// a file matched by dedupFilter.
+func blobPath(file models.File, hash string, owner blobOwner) string {
+	if file.Hash == hash && (config.AppConfig.DedupScope != config.DedupUser || file.Owner == owner.Username) {
+		return file.File
+	}
+	for _, version := range file.Versions {
+		if version.Hash == hash && (config.AppConfig.DedupScope != config.DedupUser || version.UploadedBy == owner.Username) {
+			return version.File
+		}
+	}
+	return ""
+}
+
+// findBlob returns the path of the physical file holding the content with
+// the given hash, or "" if it is not stored within owner's deduplication
+// scope yet.
+func findBlob(ctx context.Context, hash string, owner blobOwner) (string, error) {
+	filter := dedupFilter(hash, owner)
+	if filter == nil {
+		return "", nil
+	}
+	var existing models.File
+	opts := options.FindOne().SetProjection(bson.M{"file": 1, "hash": 1, "owner": 1, "versions": 1})
+	err := database.FileCollection.FindOne(ctx, filter, opts).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return blobPath(existing, hash, owner), nil
+}
+
+// detectType works out the type of content from its leading bytes. Clients
+// often declare application/octet-stream or a wrong type, so the declared
+// type is only used when the content is not recognised. It returns the type
+// to store, the declared type and whether the two contradict each other.
+func detectType(src io.ReaderAt, filename, declared string) (string, string, bool) {
+	header := make([]byte, sniff.HeaderSize)
+	n, err := src.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		log.Printf("Could not read %s to detect its type: %v", filename, err)
+		return declared, declared, false
+	}
+	detected := sniff.Detect(header[:n], filename)
+	if detected == sniff.Octet && declared != "" {
+		return sniff.Normalize(declared), declared, false
+	}
+	return detected, declared, sniff.Mismatch(declared, detected)
+}
+
+// extractContent extracts the text of supported documents for content
+// search. A document that cannot be parsed is still stored, just not
+// searchable.
+func extractContent(src io.ReaderAt, size int64, filename, contentType string) string {
+	content, err := extract.Text(src, size, filename, contentType, config.AppConfig.MaxExtractedText)
+	if err != nil && err != extract.ErrUnsupported {
+		log.Printf("Could not extract text from %s: %v", filename, err)
+	}
+	return content
+}
+
+// releaseBlob deletes a physical file once no file or version references it
+// any more, along with the thumbnails of its content. Content with the same
+// hash may be stored more than once when deduplication is scoped, so
+// references are counted by path. Entries in the trash still count as
+// references, so their blobs survive until they are purged.
+func releaseBlob(ctx context.Context, path, hash string) {
+	// Check if any other files reference the same physical file
+	filter := bson.M{"$or": bson.A{bson.M{"file": path}, bson.M{"versions.file": path}}}
+	count, err := database.FileCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Printf("Error checking for other file references: %v", err)
+		// Continue without deleting the physical file to be safe
+		return
+	}
+	if count == 0 {
+		// The path in the DB is like "/uploads/...", so we remove the leading "/"
+		physicalPath := strings.TrimPrefix(path, "/")
+		if err := os.Remove(physicalPath); err != nil {
+			log.Printf("Failed to delete physical file %s: %v", physicalPath, err)
+		}
+		releaseDerivedImages(ctx, hash)
+	}
+}
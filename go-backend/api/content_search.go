@@ -0,0 +1,53 @@
+package api
+
+import (
+	"file-hub-go/models"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// snippetRadius is roughly how many bytes of context a snippet shows on each
+// side of the first match.
+const snippetRadius = 80
+
+// contentSnippet cuts an excerpt out of content around the first occurrence
+// of any of the search words and marks every occurrence inside it. Matching
+// is case-insensitive. Without a match the start of the content is used.
+func contentSnippet(content string, words []string) *models.Snippet {
+	if content == "" || len(words) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+	re, err := regexp.Compile("(?i)" + strings.Join(quoted, "|"))
+	if err != nil {
+		return nil
+	}
+
+	start, end := 0, 2*snippetRadius
+	if loc := re.FindStringIndex(content); loc != nil {
+		start, end = loc[0]-snippetRadius, loc[1]+snippetRadius
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > len(content) {
+		end = len(content)
+	}
+	// Move the window edges onto rune boundaries.
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+
+	snippet := &models.Snippet{Text: content[start:end], Highlights: [][2]int{}}
+	for _, loc := range re.FindAllStringIndex(snippet.Text, -1) {
+		snippet.Highlights = append(snippet.Highlights, [2]int{loc[0], loc[1]})
+	}
+	return snippet
+}
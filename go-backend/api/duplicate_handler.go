@@ -0,0 +1,313 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"file-hub-go/audit"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultDuplicateGroups = 20
+	maxDuplicateGroups     = 100
+	// maxGroupFiles bounds the files listed per group; Count is always exact.
+	maxGroupFiles = 100
+)
+
+// DuplicateFile is one logical file that shares its content with others.
+type DuplicateFile struct {
+	ID          string    `bson:"_id" json:"id"`
+	Name        string    `bson:"original_filename" json:"original_filename"`
+	Owner       string    `bson:"owner" json:"owner"`
+	WorkspaceID string    `bson:"workspace_id" json:"workspace_id"`
+	FolderID    string    `bson:"folder_id" json:"folder_id"`
+	UploadedAt  time.Time `bson:"uploaded_at" json:"uploaded_at"`
+}
+
+// DuplicateGroup is a set of files with the same content. SavedBytes is
+// what deduplication saves for this group.
+type DuplicateGroup struct {
+	Hash          string          `bson:"_id" json:"hash"`
+	Count         int64           `bson:"count" json:"count"`
+	PhysicalBytes int64           `bson:"physical_bytes" json:"physical_bytes"`
+	LogicalBytes  int64           `bson:"logical_bytes" json:"logical_bytes"`
+	SavedBytes    int64           `bson:"saved_bytes" json:"saved_bytes"`
+	Files         []DuplicateFile `bson:"files" json:"files"`
+}
+
+// DuplicateReport summarizes deduplication over the files in scope. The
+// groups are the ones that save the most space, largest first.
+type DuplicateReport struct {
+	Scope         string           `json:"scope"`
+	Files         int64            `json:"files"`
+	DuplicateSets int64            `json:"duplicate_sets"`
+	PhysicalBytes int64            `json:"physical_bytes"`
+	LogicalBytes  int64            `json:"logical_bytes"`
+	SavedBytes    int64            `json:"saved_bytes"`
+	Groups        []DuplicateGroup `json:"groups"`
+}
+
+// duplicateScope reads the scope parameter and returns the filter for the
+// files it covers. "mine" (the default) is the caller's own files; "all" is
+// every file and is reserved for admins.
+func duplicateScope(w http.ResponseWriter, r *http.Request) (string, bson.M, bool) {
+	filter := bson.M{"deleted_at": nil, "hash": bson.M{"$nin": bson.A{nil, ""}}}
+	switch scope := r.URL.Query().Get("scope"); scope {
+	case "", "mine":
+		filter["owner"] = currentUser(r)
+		return "mine", filter, true
+	case "all":
+		if !isAdmin(currentUser(r)) {
+			http.Error(w, "Only admins can report on all files", http.StatusForbidden)
+			return "", nil, false
+		}
+		return scope, filter, true
+	default:
+		http.Error(w, "scope must be mine or all", http.StatusBadRequest)
+		return "", nil, false
+	}
+}
+
+// GetDuplicates reports files that share content, grouped by hash, with the
+// storage the groups take up physically and logically. `limit` sets how
+// many of the largest groups to list.
+func GetDuplicates(w http.ResponseWriter, r *http.Request) {
+	scope, filter, ok := duplicateScope(w, r)
+	if !ok {
+		return
+	}
+	limit := defaultDuplicateGroups
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxDuplicateGroups {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxDuplicateGroups), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Every file with a given hash has the same size. It takes up that size
+	// once per physical file, which is once unless deduplication is scoped,
+	// and once per file logically.
+	byHash := func(stages ...bson.D) mongo.Pipeline {
+		return append(mongo.Pipeline{
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: "$hash"},
+				{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+				{Key: "size", Value: bson.D{{Key: "$max", Value: "$size"}}},
+				{Key: "paths", Value: bson.D{{Key: "$addToSet", Value: "$file"}}},
+				{Key: "logical_bytes", Value: bson.D{{Key: "$sum", Value: "$size"}}},
+			}}},
+			{{Key: "$project", Value: bson.D{
+				{Key: "count", Value: 1},
+				{Key: "logical_bytes", Value: 1},
+				{Key: "physical_bytes", Value: bson.D{{Key: "$multiply", Value: bson.A{"$size", bson.D{{Key: "$size", Value: "$paths"}}}}}},
+			}}},
+		}, stages...)
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$facet", Value: bson.D{
+			{Key: "totals", Value: byHash(
+				bson.D{{Key: "$group", Value: bson.D{
+					{Key: "_id", Value: nil},
+					{Key: "files", Value: bson.D{{Key: "$sum", Value: "$count"}}},
+					{Key: "duplicate_sets", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{bson.D{{Key: "$gt", Value: bson.A{"$count", 1}}}, 1, 0}}}}}},
+					{Key: "physical_bytes", Value: bson.D{{Key: "$sum", Value: "$physical_bytes"}}},
+					{Key: "logical_bytes", Value: bson.D{{Key: "$sum", Value: "$logical_bytes"}}},
+				}}},
+			)},
+			{Key: "groups", Value: byHash(
+				bson.D{{Key: "$match", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+				bson.D{{Key: "$addFields", Value: bson.D{{Key: "saved_bytes", Value: bson.D{{Key: "$subtract", Value: bson.A{"$logical_bytes", "$physical_bytes"}}}}}}},
+				bson.D{{Key: "$sort", Value: bson.D{{Key: "saved_bytes", Value: -1}, {Key: "_id", Value: 1}}}},
+				bson.D{{Key: "$limit", Value: limit}},
+				// Look the files up only for the groups that are listed.
+				bson.D{{Key: "$lookup", Value: bson.D{
+					{Key: "from", Value: database.FileCollection.Name()},
+					{Key: "let", Value: bson.D{{Key: "hash", Value: "$_id"}}},
+					{Key: "pipeline", Value: mongo.Pipeline{
+						{{Key: "$match", Value: filter}},
+						{{Key: "$match", Value: bson.D{{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{"$hash", "$$hash"}}}}}}},
+						{{Key: "$sort", Value: bson.D{{Key: "uploaded_at", Value: 1}}}},
+						{{Key: "$limit", Value: maxGroupFiles}},
+						{{Key: "$project", Value: bson.D{
+							{Key: "original_filename", Value: 1},
+							{Key: "owner", Value: 1},
+							{Key: "workspace_id", Value: 1},
+							{Key: "folder_id", Value: 1},
+							{Key: "uploaded_at", Value: 1},
+						}}},
+					}},
+					{Key: "as", Value: "files"},
+				}}},
+			)},
+		}}},
+	}
+
+	cursor, err := database.FileCollection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		http.Error(w, "Failed to compute duplicate report", http.StatusInternalServerError)
+		log.Printf("Error aggregating duplicates: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Totals []struct {
+			Files         int64 `bson:"files"`
+			DuplicateSets int64 `bson:"duplicate_sets"`
+			PhysicalBytes int64 `bson:"physical_bytes"`
+			LogicalBytes  int64 `bson:"logical_bytes"`
+		} `bson:"totals"`
+		Groups []DuplicateGroup `bson:"groups"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		http.Error(w, "Failed to compute duplicate report", http.StatusInternalServerError)
+		log.Printf("Error decoding duplicates: %v", err)
+		return
+	}
+
+	report := DuplicateReport{Scope: scope, Groups: []DuplicateGroup{}}
+	if len(result) > 0 {
+		if len(result[0].Totals) > 0 {
+			totals := result[0].Totals[0]
+			report.Files = totals.Files
+			report.DuplicateSets = totals.DuplicateSets
+			report.PhysicalBytes = totals.PhysicalBytes
+			report.LogicalBytes = totals.LogicalBytes
+			report.SavedBytes = totals.LogicalBytes - totals.PhysicalBytes
+		}
+		if result[0].Groups != nil {
+			report.Groups = result[0].Groups
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// CollapseRequest is the body of the collapse endpoint.
+type CollapseRequest struct {
+	Keep string `json:"keep"` // Optional, defaults to the oldest file
+}
+
+// CollapseResponse lists the file that was kept and the ones moved to the trash.
+type CollapseResponse struct {
+	Kept    string   `json:"kept"`
+	Trashed []string `json:"trashed"`
+}
+
+// CollapseDuplicates reduces a duplicate group to one file. Every other
+// file in the group that is in scope and that the caller can change is moved
+// to the trash, from where it can be restored.
+func CollapseDuplicates(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+	_, filter, ok := duplicateScope(w, r)
+	if !ok {
+		return
+	}
+	var req CollapseRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter["hash"] = hash
+	var files []models.File
+	opts := options.Find().
+		SetProjection(bson.M{"content": 0, "versions": 0}).
+		SetSort(bson.D{{Key: "uploaded_at", Value: 1}})
+	cursor, err := database.FileCollection.Find(ctx, filter, opts)
+	if err == nil {
+		err = cursor.All(ctx, &files)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch files from database", http.StatusInternalServerError)
+		log.Printf("Error fetching duplicates of %s: %v", hash, err)
+		return
+	}
+
+	// Admins may collapse anyone's files; everybody else only those they
+	// can write to.
+	username := currentUser(r)
+	admin := isAdmin(username)
+	roles := map[string]string{}
+	var writable []models.File
+	for _, file := range files {
+		if !admin {
+			role, ok := roles[file.WorkspaceID]
+			if !ok {
+				if role, err = workspaceRole(ctx, username, file.WorkspaceID); err != nil {
+					http.Error(w, "Failed to fetch workspace from database", http.StatusInternalServerError)
+					return
+				}
+				roles[file.WorkspaceID] = role
+			}
+			if !canWrite(role) {
+				continue
+			}
+		}
+		writable = append(writable, file)
+	}
+	if len(writable) == 0 {
+		http.Error(w, "No duplicates found", http.StatusNotFound)
+		return
+	}
+
+	kept := writable[0]
+	if req.Keep != "" {
+		found := false
+		for _, file := range writable {
+			if file.ID == req.Keep {
+				kept, found = file, true
+				break
+			}
+		}
+		if !found {
+			http.Error(w, "keep must be one of the duplicates", http.StatusBadRequest)
+			return
+		}
+	}
+
+	response := CollapseResponse{Kept: kept.ID, Trashed: []string{}}
+	for _, file := range writable {
+		if file.ID != kept.ID {
+			response.Trashed = append(response.Trashed, file.ID)
+		}
+	}
+	if len(response.Trashed) > 0 {
+		if err := trashFiles(ctx, username, bson.M{"_id": bson.M{"$in": response.Trashed}}); err != nil {
+			http.Error(w, "Failed to delete duplicates", http.StatusInternalServerError)
+			log.Printf("Error collapsing duplicates of %s: %v", hash, err)
+			return
+		}
+		audit.Log(username, "file.collapse", kept.ID, map[string]string{
+			"hash":    hash,
+			"trashed": strings.Join(response.Trashed, ","),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"file-hub-go/database"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxFacetBuckets bounds the buckets returned for open-ended facets such as
+// tags and owners.
+const maxFacetBuckets = 50
+
+// sizeBoundaries are the lower bounds of the size facet buckets, in bytes.
+var sizeBoundaries = []int64{0, 100 << 10, 1 << 20, 10 << 20, 100 << 20, 1 << 30}
+
+// FacetBucket is one value of a facet and the number of matching files.
+// Min and Max are set for size buckets; Max is omitted for the last one.
+type FacetBucket struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+	Min   *int64 `json:"min,omitempty"`
+	Max   *int64 `json:"max,omitempty"`
+}
+
+// Facets holds the aggregation buckets for a file listing.
+type Facets struct {
+	Total         int64         `json:"total"`
+	FileType      []FacetBucket `json:"file_type"`
+	Category      []FacetBucket `json:"category"`
+	Size          []FacetBucket `json:"size"`
+	UploadedMonth []FacetBucket `json:"uploaded_month"`
+	Tags          []FacetBucket `json:"tags"`
+	Owner         []FacetBucket `json:"owner"`
+}
+
+// countBy groups by a field expression and returns the most common values first.
+func countBy(expr interface{}, limit int) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: expr}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+}
+
+// formatSize renders a byte count with a binary unit, e.g. 10MB.
+func formatSize(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for n >= 1024 && n%1024 == 0 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%d%s", n, units[i])
+}
+
+// GetFileFacets returns facet counts for the files matching the same
+// filters as GetFiles.
+func GetFileFacets(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query, ok := resolveFileQuery(ctx, w, r, false)
+	if !ok {
+		return
+	}
+
+	boundaries := bson.A{}
+	for _, b := range sizeBoundaries {
+		boundaries = append(boundaries, b)
+	}
+	// $bucket needs an upper bound for the last bucket; anything larger
+	// falls into the default bucket.
+	boundaries = append(boundaries, int64(1)<<62)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: query.Filter}},
+		{{Key: "$facet", Value: bson.D{
+			{Key: "total", Value: mongo.Pipeline{{{Key: "$count", Value: "count"}}}},
+			{Key: "file_type", Value: countBy("$file_type", maxFacetBuckets)},
+			{Key: "category", Value: countBy(bson.D{{Key: "$arrayElemAt", Value: bson.A{
+				bson.D{{Key: "$split", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$file_type", ""}}}, "/"}}}, 0,
+			}}}, maxFacetBuckets)},
+			{Key: "size", Value: mongo.Pipeline{
+				{{Key: "$bucket", Value: bson.D{
+					{Key: "groupBy", Value: "$size"},
+					{Key: "boundaries", Value: boundaries},
+					{Key: "default", Value: "unknown"},
+					{Key: "output", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}},
+				}}},
+			}},
+			{Key: "uploaded_month", Value: mongo.Pipeline{
+				{{Key: "$group", Value: bson.D{
+					{Key: "_id", Value: bson.D{{Key: "$dateToString", Value: bson.D{{Key: "format", Value: "%Y-%m"}, {Key: "date", Value: "$uploaded_at"}}}}},
+					{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+				}}},
+				{{Key: "$sort", Value: bson.D{{Key: "_id", Value: -1}}}},
+				{{Key: "$limit", Value: 120}},
+			}},
+			{Key: "tags", Value: append(mongo.Pipeline{{{Key: "$unwind", Value: "$tags"}}}, countBy("$tags", maxFacetBuckets)...)},
+			{Key: "owner", Value: countBy("$owner", maxFacetBuckets)},
+		}}},
+	}
+
+	cursor, err := database.FileCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		http.Error(w, "Failed to compute facets", http.StatusInternalServerError)
+		log.Printf("Error aggregating facets: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	type bucket struct {
+		ID    interface{} `bson:"_id"`
+		Count int64       `bson:"count"`
+	}
+	var results []struct {
+		Total         []struct{ Count int64 } `bson:"total"`
+		FileType      []bucket                `bson:"file_type"`
+		Category      []bucket                `bson:"category"`
+		Size          []bucket                `bson:"size"`
+		UploadedMonth []bucket                `bson:"uploaded_month"`
+		Tags          []bucket                `bson:"tags"`
+		Owner         []bucket                `bson:"owner"`
+	}
+	if err := cursor.All(ctx, &results); err != nil || len(results) != 1 {
+		http.Error(w, "Failed to decode facets", http.StatusInternalServerError)
+		log.Printf("Error decoding facets: %v", err)
+		return
+	}
+	result := results[0]
+
+	convert := func(buckets []bucket) []FacetBucket {
+		out := []FacetBucket{}
+		for _, b := range buckets {
+			value := ""
+			if b.ID != nil {
+				value = fmt.Sprint(b.ID)
+			}
+			out = append(out, FacetBucket{Value: value, Count: b.Count})
+		}
+		return out
+	}
+
+	facets := Facets{
+		FileType:      convert(result.FileType),
+		Category:      convert(result.Category),
+		UploadedMonth: convert(result.UploadedMonth),
+		Tags:          convert(result.Tags),
+		Owner:         convert(result.Owner),
+		Size:          []FacetBucket{},
+	}
+	if len(result.Total) > 0 {
+		facets.Total = result.Total[0].Count
+	}
+
+	// Label size buckets by their range, e.g. "1MB-10MB" or "1GB+".
+	for _, b := range result.Size {
+		lower, ok := b.ID.(int64)
+		if !ok {
+			if n, isInt := b.ID.(int32); isInt {
+				lower, ok = int64(n), true
+			}
+		}
+		if !ok {
+			facets.Size = append(facets.Size, FacetBucket{Value: fmt.Sprint(b.ID), Count: b.Count})
+			continue
+		}
+		bucket := FacetBucket{Count: b.Count, Min: &lower}
+		bucket.Value = formatSize(lower) + "+"
+		for i, boundary := range sizeBoundaries {
+			if boundary == lower && i+1 < len(sizeBoundaries) {
+				upper := sizeBoundaries[i+1]
+				bucket.Max = &upper
+				bucket.Value = formatSize(lower) + "-" + formatSize(upper)
+			}
+		}
+		facets.Size = append(facets.Size, bucket)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(facets)
+}
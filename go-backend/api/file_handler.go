@@ -4,15 +4,18 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"file-hub-go/audit"
 	"file-hub-go/config"
 	"file-hub-go/database"
 	"file-hub-go/models"
 	"fmt"
 	"io"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -21,26 +24,33 @@ import (
 	"github.com/google/uuid"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// GetFiles handles the logic for listing and filtering files.
-func GetFiles(w http.ResponseWriter, r *http.Request) {
+// buildFileFilter turns the listing query parameters into a MongoDB filter.
+// The search query must parse; other values that cannot be parsed are ignored.
+// The words of a content search, if any, are returned for highlighting.
+func buildFileFilter(params url.Values) (bson.D, []string, error) {
 	// A filter document for our MongoDB query. bson.D preserves order.
 	filter := bson.D{}
-	params := r.URL.Query()
+	var contentWords []string
 
 	// --- Filtering Logic (similar to your Django backend) ---
 
-	// Search by filename (case-insensitive)
+	// Search using the query language in search_query.go
 	if search := params.Get("search"); search != "" {
-		// This creates a filter like: { "original_filename": { "$regex": "...", "$options": "i" } }
-		filter = append(filter, bson.E{Key: "original_filename", Value: bson.D{{Key: "$regex", Value: search}, {Key: "$options", Value: "i"}}})
+		query, err := parseSearchQuery(search)
+		if err != nil {
+			return nil, nil, err
+		}
+		filter = append(filter, query.Filter...)
+		contentWords = query.ContentWords
 	}
 
-	// Filter by file type (case-insensitive)
+	// Filter by detected file type (case-insensitive substring, e.g. "image/")
 	if fileType := params.Get("file_type"); fileType != "" {
-		filter = append(filter, bson.E{Key: "file_type", Value: bson.D{{Key: "$regex", Value: fileType}, {Key: "$options", Value: "i"}}})
+		filter = append(filter, bson.E{Key: "file_type", Value: literalPattern(fileType, false)})
 	}
 
 	// Filter by min size
@@ -73,17 +83,143 @@ func GetFiles(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Filter by tags: tags_any matches files with at least one of the
+	// comma-separated tags, tags_all only files that carry every one of them.
+	if tags := splitTags(params.Get("tags_any")); len(tags) > 0 {
+		filter = append(filter, bson.E{Key: "tags", Value: bson.D{{Key: "$in", Value: tags}}})
+	}
+	if tags := splitTags(params.Get("tags_all")); len(tags) > 0 {
+		filter = append(filter, bson.E{Key: "tags", Value: bson.D{{Key: "$all", Value: tags}}})
+	}
+
+	// Filter by folder. "root" selects files that are not in any folder.
+	if folder := params.Get("folder"); folder != "" {
+		filter = append(filter, bson.E{Key: "folder_id", Value: folderIDValue(folder)})
+	}
+
 	// --- End Filtering ---
+	return filter, contentWords, nil
+}
+
+// fileQuery is a file listing request resolved against the database: the
+// filter to run and the workspace and folder it applies to.
+type fileQuery struct {
+	Filter       bson.D
+	ContentWords []string // Set for content searches
+	WorkspaceID  string
+	FolderID     string
+	Breadcrumbs  []models.Breadcrumb
+}
+
+// resolveFileQuery builds the filter for the listing parameters and checks
+// the caller's read access. Only files in the trash are matched when trashed
+// is set, and only files outside it otherwise. It writes an error response
+// and returns false on failure.
+func resolveFileQuery(ctx context.Context, w http.ResponseWriter, r *http.Request, trashed bool) (fileQuery, bool) {
+	params := r.URL.Query()
+	filter, contentWords, err := buildFileFilter(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return fileQuery{}, false
+	}
+	query := fileQuery{Filter: filter, ContentWords: contentWords}
+
+	// Files are listed per workspace. When a folder is requested the
+	// workspace is the folder's own; otherwise it comes from the `workspace`
+	// parameter and defaults to the shared default workspace.
+	query.WorkspaceID = params.Get("workspace")
+	query.FolderID = params.Get("folder")
+	if query.FolderID != "" && query.FolderID != rootFolder {
+		folder, ok := authorizeFolder(ctx, w, r, query.FolderID, false)
+		if !ok {
+			return query, false
+		}
+		query.WorkspaceID = folder.WorkspaceID
+
+		var err error
+		query.Breadcrumbs, err = folderBreadcrumbs(ctx, query.FolderID)
+		if err != nil {
+			http.Error(w, "Failed to fetch folder from database", http.StatusInternalServerError)
+			log.Printf("Error fetching breadcrumbs for folder %s: %v", query.FolderID, err)
+			return query, false
+		}
+	} else {
+		if !authorizeWorkspace(ctx, w, r, query.WorkspaceID, false) {
+			return query, false
+		}
+		query.Breadcrumbs = []models.Breadcrumb{}
+	}
+	query.Filter = append(query.Filter, bson.E{Key: "workspace_id", Value: emptyOr(query.WorkspaceID)})
+	if trashed {
+		query.Filter = append(query.Filter, bson.E{Key: "deleted_at", Value: bson.D{{Key: "$ne", Value: nil}}})
+	} else {
+		query.Filter = append(query.Filter, bson.E{Key: "deleted_at", Value: nil})
+	}
 
+	// Custom metadata filters are typed by the workspace's schema.
+	schema, err := loadMetadataSchema(ctx, query.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch metadata schema from database", http.StatusInternalServerError)
+		log.Printf("Error fetching metadata schema of workspace %s: %v", query.WorkspaceID, err)
+		return query, false
+	}
+	metadataFilter, err := buildMetadataFilter(params, schema)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return query, false
+	}
+	query.Filter = append(query.Filter, metadataFilter...)
+	return query, true
+}
+
+// FileListing is the response of GetFiles. NextCursor and Next are empty on
+// the last page. Total is only filled in when `count=true` is requested, and
+// Breadcrumbs and Folders only when a folder is requested.
+type FileListing struct {
+	Files       []models.File       `json:"files"`
+	NextCursor  string              `json:"next_cursor,omitempty"`
+	Next        string              `json:"next,omitempty"`
+	Total       *int64              `json:"total,omitempty"`
+	Breadcrumbs []models.Breadcrumb `json:"breadcrumbs,omitempty"`
+	Folders     []models.Folder     `json:"folders,omitempty"`
+}
+
+// GetFiles handles the logic for listing and filtering files. Results are
+// paged with an opaque cursor; see parsePageRequest for the parameters.
+// Files in the trash are not included.
+func GetFiles(w http.ResponseWriter, r *http.Request) {
+	listFiles(w, r, false)
+}
+
+func listFiles(w http.ResponseWriter, r *http.Request, trashed bool) {
 	var files []models.File
 	// Set a timeout for the database operation.
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	query, ok := resolveFileQuery(ctx, w, r, trashed)
+	if !ok {
+		return
+	}
+	page, err := parsePageRequest(r.URL.Query(), len(query.ContentWords) > 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Continue after the cursor, if any, in the requested order.
+	pageFilter := query.Filter
+	after, err := page.afterFilter()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if after != nil {
+		pageFilter = bson.D{{Key: "$and", Value: bson.A{query.Filter, after}}}
+	}
+
 	// Find documents in the collection that match our filter.
-	// We also sort by `uploaded_at` in descending order.
-	opts := options.Find().SetSort(bson.D{{Key: "uploaded_at", Value: -1}})
-	cursor, err := database.FileCollection.Find(ctx, filter, opts)
+	cursor, err := database.FileCollection.Find(ctx, pageFilter, page.findOptions())
 	if err != nil {
 		http.Error(w, "Failed to fetch files from database", http.StatusInternalServerError)
 		log.Printf("Error fetching files: %v", err)
@@ -99,13 +235,281 @@ func GetFiles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// If no files are found, return an empty JSON array `[]` instead of `null`.
-	if files == nil {
-		files = []models.File{}
+	listing := FileListing{Files: files}
+	if listing.Files == nil {
+		listing.Files = []models.File{}
+	}
+
+	// The extra document tells us there is another page.
+	if int64(len(listing.Files)) > page.Limit {
+		listing.Files = listing.Files[:page.Limit]
+		last := listing.Files[len(listing.Files)-1]
+		var value interface{}
+		switch page.Sort {
+		case "name":
+			value = last.OriginalFilename
+		case "size":
+			value = last.Size
+		case "type":
+			value = last.FileType
+		case sortRelevance:
+			value = page.offset() + page.Limit
+		default:
+			value = last.UploadedAt
+		}
+		listing.NextCursor = page.nextCursor(value, last.ID)
+		listing.Next = nextLink(r.URL, listing.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", listing.Next))
+	}
+
+	// Content searches show where in the document the words were found.
+	if len(query.ContentWords) > 0 {
+		for i := range listing.Files {
+			listing.Files[i].Snippet = contentSnippet(listing.Files[i].Content, query.ContentWords)
+		}
+	}
+
+	if page.Count {
+		total, err := database.FileCollection.CountDocuments(ctx, query.Filter)
+		if err != nil {
+			http.Error(w, "Failed to count files", http.StatusInternalServerError)
+			log.Printf("Error counting files: %v", err)
+			return
+		}
+		listing.Total = &total
+	}
+
+	if query.FolderID != "" {
+		listing.Breadcrumbs = query.Breadcrumbs
+		listing.Folders, err = childFolders(ctx, query.WorkspaceID, query.FolderID)
+		if err != nil {
+			http.Error(w, "Failed to fetch folders from database", http.StatusInternalServerError)
+			log.Printf("Error fetching subfolders of %s: %v", query.FolderID, err)
+			return
+		}
+	}
+
+	// Set the response header and encode the listing as JSON.
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listing)
+}
+
+// GetFile returns the metadata of a single file.
+func GetFile(w http.ResponseWriter, r *http.Request) {
+	fileID := chi.URLParam(r, "id")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	file, ok := authorizeFile(ctx, w, r, fileID, false)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file)
+}
+
+// DownloadFile streams the current content of a file. Content is only
+// served through here, after an access check, so the location of the
+// physical file is never exposed.
+func DownloadFile(w http.ResponseWriter, r *http.Request) {
+	fileID := chi.URLParam(r, "id")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	file, ok := authorizeFile(ctx, w, r, fileID, false)
+	if !ok || !authorizeDownload(w, file.Scan) {
+		return
+	}
+
+	physicalPath := strings.TrimPrefix(file.File, "/")
+	content, err := os.Open(physicalPath)
+	if err != nil {
+		http.Error(w, "File content not found", http.StatusNotFound)
+		log.Printf("Error opening %s: %v", physicalPath, err)
+		return
+	}
+	defer content.Close()
+
+	if file.FileType != "" {
+		w.Header().Set("Content-Type", file.FileType)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.OriginalFilename}))
+	http.ServeContent(w, r, file.OriginalFilename, file.UpdatedAt, content)
+}
+
+// FileUpdate holds the editable fields of a file. Fields left out of the
+// request body are nil and remain unchanged.
+type FileUpdate struct {
+	OriginalFilename *string   `json:"original_filename"`
+	Description      *string   `json:"description"`
+	Tags             *[]string `json:"tags"` // Replaces all tags
+	// Metadata is merged into the existing metadata; a null value removes a key.
+	Metadata map[string]interface{} `json:"metadata"`
+	// MaxVersions limits the versions kept of this file from its next
+	// version on; 0 falls back to the global limit.
+	MaxVersions *int `json:"max_versions"`
+}
+
+const (
+	maxFilenameLength    = 255
+	maxDescriptionLength = 2000
+)
+
+// validateName checks that a user-supplied file or folder name is usable as a
+// display name and as a download name. field names the offending field in
+// the error message.
+func validateName(field, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("%s must not be empty", field)
+	}
+	if len(name) > maxFilenameLength {
+		return fmt.Errorf("%s must be at most %d characters", field, maxFilenameLength)
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("%s must not contain path separators", field)
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f {
+			return fmt.Errorf("%s must not contain control characters", field)
+		}
+	}
+	return nil
+}
+
+// UpdateFile applies a partial update to a file's editable metadata and
+// returns the updated document.
+func UpdateFile(w http.ResponseWriter, r *http.Request) {
+	fileID := chi.URLParam(r, "id")
+
+	var update FileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	set := bson.D{}
+	details := map[string]string{}
+	if update.OriginalFilename != nil {
+		if err := validateName("original_filename", *update.OriginalFilename); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		set = append(set, bson.E{Key: "original_filename", Value: *update.OriginalFilename})
+		details["original_filename"] = *update.OriginalFilename
+	}
+	if update.Description != nil {
+		if len(*update.Description) > maxDescriptionLength {
+			http.Error(w, fmt.Sprintf("description must be at most %d characters", maxDescriptionLength), http.StatusBadRequest)
+			return
+		}
+		set = append(set, bson.E{Key: "description", Value: *update.Description})
+		details["description"] = *update.Description
+	}
+	if update.Tags != nil {
+		tags, err := normalizeTags(*update.Tags)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		set = append(set, bson.E{Key: "tags", Value: tags})
+		details["tags"] = strings.Join(tags, ",")
+	}
+	if update.MaxVersions != nil {
+		if *update.MaxVersions < 0 {
+			http.Error(w, "max_versions must not be negative", http.StatusBadRequest)
+			return
+		}
+		set = append(set, bson.E{Key: "max_versions", Value: *update.MaxVersions})
+		details["max_versions"] = strconv.Itoa(*update.MaxVersions)
+	}
+	if len(set) == 0 && len(update.Metadata) == 0 {
+		http.Error(w, "No editable fields provided", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	file, ok := authorizeFile(ctx, w, r, fileID, true)
+	if !ok {
+		return
+	}
+	// A rename must not turn the file into one the policies would reject.
+	if update.OriginalFilename != nil && !enforceUploadPolicy(ctx, w, file.WorkspaceID, *update.OriginalFilename, file.FileType) {
+		return
+	}
+
+	unset := bson.D{}
+	if len(update.Metadata) > 0 {
+		schema, err := loadMetadataSchema(ctx, file.WorkspaceID)
+		if err != nil {
+			http.Error(w, "Failed to fetch metadata schema from database", http.StatusInternalServerError)
+			return
+		}
+
+		// Validate the values being set, then check the merged result so a
+		// required field cannot be removed.
+		merged := map[string]interface{}{}
+		for key, value := range file.Metadata {
+			merged[key] = value
+		}
+		changes := map[string]interface{}{}
+		for key, value := range update.Metadata {
+			if value != nil {
+				changes[key] = value
+				continue
+			}
+			if !metadataKeyPattern.MatchString(key) {
+				http.Error(w, fmt.Sprintf("invalid metadata key %q", key), http.StatusBadRequest)
+				return
+			}
+			delete(merged, key)
+			unset = append(unset, bson.E{Key: "metadata." + key, Value: ""})
+			details["metadata."+key] = ""
+		}
+		parsed, err := validateMetadata(schema, changes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for key, value := range parsed {
+			merged[key] = value
+			set = append(set, bson.E{Key: "metadata." + key, Value: value})
+			details["metadata."+key] = fmt.Sprint(value)
+		}
+		if len(merged) > maxMetadataFields {
+			http.Error(w, fmt.Sprintf("a file can have at most %d metadata fields", maxMetadataFields), http.StatusBadRequest)
+			return
+		}
+		if err := checkRequiredMetadata(schema, merged); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	set = append(set, bson.E{Key: "updated_at", Value: time.Now()})
+
+	changes := bson.D{{Key: "$set", Value: set}}
+	if len(unset) > 0 {
+		changes = append(changes, bson.E{Key: "$unset", Value: unset})
+	}
+
+	var updated models.File
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := database.FileCollection.FindOneAndUpdate(ctx, bson.M{"_id": fileID, "deleted_at": nil}, changes, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
 	}
+	if err != nil {
+		http.Error(w, "Failed to update file metadata", http.StatusInternalServerError)
+		log.Printf("Error updating file %s: %v", fileID, err)
+		return
+	}
+	audit.Log(currentUser(r), "file.update", fileID, details)
 
-	// Set the response header and encode the files slice as JSON.
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(files)
+	json.NewEncoder(w).Encode(updated)
 }
 
 // calculateFileHash calculates the SHA256 hash of a file.
@@ -127,90 +531,130 @@ func calculateFileHash(file io.ReadSeeker) (string, error) {
 	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
 }
 
-// UploadFile handles the logic for uploading a new file.
-func UploadFile(w http.ResponseWriter, r *http.Request) {
-	var newFile models.File
+// uploadedFile parses a multipart upload and returns its "file" part,
+// writing an error response on failure.
+func uploadedFile(w http.ResponseWriter, r *http.Request) (multipart.File, *multipart.FileHeader, bool) {
 	// Parse the multipart form, with a max file size from config
 	if err := r.ParseMultipartForm(config.AppConfig.MaxUploadSize); err != nil {
 		maxSizeMB := config.AppConfig.MaxUploadSize / 1024 / 1024
 		http.Error(w, fmt.Sprintf("The uploaded file is too big. Please choose a file less than %dMB.", maxSizeMB), http.StatusBadRequest)
-		return
+		return nil, nil, false
 	}
 	// Get the file from the form data
 	file, handler, err := r.FormFile("file")
 	if err != nil {
 		http.Error(w, "Invalid file", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	return file, handler, true
+}
+
+// newUploadedFile builds the metadata entry, with its first version, for
+// content saved by storeBlob.
+func newUploadedFile(blob storedBlob, filename, owner string) models.File {
+	newFile := models.File{
+		ID:               uuid.New().String(),
+		File:             blob.Path,
+		OriginalFilename: filename,
+		FileType:         blob.FileType,
+		DeclaredType:     blob.DeclaredType,
+		TypeMismatch:     blob.TypeMismatch,
+		Scan:             blob.Scan,
+		Size:             blob.Size,
+		Hash:             blob.Hash,
+		Content:          blob.Content,
+		Owner:            owner,
+		UploadedAt:       time.Now(),
+		Version:          1,
+	}
+	newFile.UpdatedAt = newFile.UploadedAt
+	newFile.Versions = []models.FileVersion{newVersion(newFile, 1, owner, newFile.UploadedAt)}
+	return newFile
+}
+
+// UploadFile handles the logic for uploading a new file.
+func UploadFile(w http.ResponseWriter, r *http.Request) {
+	file, handler, ok := uploadedFile(w, r)
+	if !ok {
 		return
 	}
 	defer file.Close()
 
-	// Calculate the file hash for deduplication
-	fileHash, err := calculateFileHash(file)
-	if err != nil {
-		http.Error(w, "Could not calculate file hash", http.StatusInternalServerError)
+	// Optionally place the file in a workspace and folder
+	workspaceID := r.FormValue("workspace_id")
+	folderID := r.FormValue("folder_id")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if !authorizeWorkspace(ctx, w, r, workspaceID, true) {
+		return
+	}
+	if exists, err := folderInWorkspace(ctx, folderID, workspaceID); err != nil {
+		http.Error(w, "Failed to fetch folder from database", http.StatusInternalServerError)
+		return
+	} else if !exists {
+		http.Error(w, "Folder not found", http.StatusBadRequest)
 		return
 	}
-	// --- Deduplication Logic ---
-	var existingFile models.File
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
 
-	// Check if a file with the same hash already exists
-	err = database.FileCollection.FindOne(ctx, bson.M{"hash": fileHash}).Decode(&existingFile)
+	// Custom metadata arrives as `metadata.<key>` form fields and is typed
+	// and checked against the workspace's schema.
+	schema, err := loadMetadataSchema(ctx, workspaceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch metadata schema from database", http.StatusInternalServerError)
+		return
+	}
+	metadata, err := validateMetadata(schema, metadataFromForm(r.MultipartForm.Value))
 	if err == nil {
-		// DUPLICATE: A file with this hash exists. Create a new metadata entry
-		// pointing to the same physical file.
-		newFile = models.File{
-			ID:               uuid.New().String(),
-			File:             existingFile.File, // Point to the existing file path
-			OriginalFilename: handler.Filename,
-			FileType:         handler.Header.Get("Content-Type"),
-			Size:             handler.Size,
-			Hash:             fileHash,
-			UploadedAt:       time.Now(),
-		}
-	} else {
-		// NEW FILE: Save the physical file and create a new metadata entry.
-		ext := filepath.Ext(handler.Filename)
-		newFilename := fmt.Sprintf("%s%s", uuid.New().String(), ext)
-		filePath := filepath.Join(config.AppConfig.UploadDir, newFilename)
+		err = checkRequiredMetadata(schema, metadata)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		// Create the destination file
-		dst, err := os.Create(filePath)
-		if err != nil {
-			http.Error(w, "Could not save file", http.StatusInternalServerError)
-			return
-		}
-		defer dst.Close()
+	// With extract=true an archive is expanded into the folder instead of
+	// being stored as a single file.
+	if extract, _ := strconv.ParseBool(r.FormValue("extract")); extract {
+		extractUpload(w, r, file, handler, workspaceID, folderID, metadata)
+		return
+	}
 
-		// Copy the uploaded file data to the destination file
-		if _, err := io.Copy(dst, file); err != nil {
-			http.Error(w, "Could not save file", http.StatusInternalServerError)
-			return
-		}
-		newFile = models.File{
-			ID:               uuid.New().String(),
-			File:             "/" + filePath, // Store the URL path
-			OriginalFilename: handler.Filename,
-			FileType:         handler.Header.Get("Content-Type"),
-			Size:             handler.Size,
-			Hash:             fileHash,
-			UploadedAt:       time.Now(),
-		}
+	// Check the upload policies before anything is written to disk.
+	fileType, _, _ := detectType(file, handler.Filename, handler.Header.Get("Content-Type"))
+	if !enforceUploadPolicy(ctx, w, workspaceID, handler.Filename, fileType) {
+		return
+	}
+
+	blob, err := storeBlob(ctx, file, handler.Size, handler.Filename, handler.Header.Get("Content-Type"), blobOwner{currentUser(r), workspaceID})
+	if err != nil {
+		writeStoreError(w, handler.Filename, err)
+		return
 	}
+	newFile := newUploadedFile(blob, handler.Filename, currentUser(r))
+	newFile.WorkspaceID = workspaceID
+	newFile.FolderID = folderID
+	newFile.Metadata = metadata
+
 	// Insert the new file metadata into the database
 	_, err = database.FileCollection.InsertOne(context.Background(), newFile)
 	if err != nil {
 		http.Error(w, "Could not save file metadata", http.StatusInternalServerError)
 		return
 	}
+	queueScan()
+	queueThumbnails(blob)
+	audit.Log(currentUser(r), "file.upload", newFile.ID, map[string]string{
+		"filename": newFile.OriginalFilename,
+		"hash":     newFile.Hash,
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(newFile)
 }
 
-// DeleteFile handles the logic for deleting a file.
+// DeleteFile moves a file to the trash. It stays there, restorable, until
+// it is purged explicitly or by the trash purger.
 func DeleteFile(w http.ResponseWriter, r *http.Request) {
 	// Get the file ID from the URL parameter
 	fileID := chi.URLParam(r, "id")
@@ -221,31 +665,18 @@ func DeleteFile(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Find the file to get its hash and path before deleting
-	var fileToDelete models.File
-	err := database.FileCollection.FindOne(ctx, bson.M{"_id": fileID}).Decode(&fileToDelete)
-	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
+	fileToDelete, ok := authorizeFile(ctx, w, r, fileID, true)
+	if !ok {
 		return
 	}
-	// Delete the metadata entry
-	_, err = database.FileCollection.DeleteOne(ctx, bson.M{"_id": fileID})
-	if err != nil {
+	if err := trashFiles(ctx, currentUser(r), bson.M{"_id": fileID}); err != nil {
 		http.Error(w, "Failed to delete file metadata", http.StatusInternalServerError)
+		log.Printf("Error trashing file %s: %v", fileID, err)
 		return
 	}
-	// Check if any other files reference the same hash
-	count, err := database.FileCollection.CountDocuments(ctx, bson.M{"hash": fileToDelete.Hash})
-	if err != nil {
-		log.Printf("Error checking for other file references: %v", err)
-		// Continue without deleting the physical file to be safe
-	} else if count == 0 {
-		// No other files with the same hash, so delete the physical file
-		// The path in the DB is like "/uploads/...", so we remove the leading "/"
-		physicalPath := strings.TrimPrefix(fileToDelete.File, "/")
-		if err := os.Remove(physicalPath); err != nil {
-			log.Printf("Failed to delete physical file %s: %v", physicalPath, err)
-		}
-	}
+	audit.Log(currentUser(r), "file.delete", fileID, map[string]string{
+		"filename": fileToDelete.OriginalFilename,
+		"hash":     fileToDelete.Hash,
+	})
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"file-hub-go/audit"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// rootFolder is the value of the `folder` query parameter that selects the
+// top level of the tree.
+const rootFolder = "root"
+
+// maxFolderDepth guards the parent walk against cycles in corrupted data.
+const maxFolderDepth = 256
+
+// folderIDValue returns the value to match folder_id/parent_id against for a
+// folder given in a request. Items at the root have an empty or missing field.
+func folderIDValue(folderID string) interface{} {
+	if folderID == rootFolder {
+		folderID = ""
+	}
+	return emptyOr(folderID)
+}
+
+// folderBreadcrumbs returns the path from the root to the given folder,
+// including the folder itself. It returns mongo.ErrNoDocuments if the folder
+// does not exist.
+func folderBreadcrumbs(ctx context.Context, folderID string) ([]models.Breadcrumb, error) {
+	breadcrumbs := []models.Breadcrumb{}
+	for id := folderID; id != "" && id != rootFolder && len(breadcrumbs) < maxFolderDepth; {
+		var folder models.Folder
+		if err := database.FolderCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&folder); err != nil {
+			return nil, err
+		}
+		breadcrumbs = append([]models.Breadcrumb{{ID: folder.ID, Name: folder.Name}}, breadcrumbs...)
+		id = folder.ParentID
+	}
+	return breadcrumbs, nil
+}
+
+// childFolders returns the direct subfolders of a folder in a workspace
+// sorted by name.
+func childFolders(ctx context.Context, workspaceID, folderID string) ([]models.Folder, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	filter := bson.M{"workspace_id": emptyOr(workspaceID), "parent_id": folderIDValue(folderID)}
+	cursor, err := database.FolderCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	folders := []models.Folder{}
+	if err := cursor.All(ctx, &folders); err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+// descendantFolderIDs returns the ID of a folder and of every folder below it.
+func descendantFolderIDs(ctx context.Context, folderID string) ([]string, error) {
+	ids := []string{folderID}
+	level := []string{folderID}
+	for depth := 0; len(level) > 0 && depth < maxFolderDepth; depth++ {
+		cursor, err := database.FolderCollection.Find(ctx, bson.M{"parent_id": bson.M{"$in": level}},
+			options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var children []models.Folder
+		if err := cursor.All(ctx, &children); err != nil {
+			return nil, err
+		}
+		level = level[:0]
+		for _, child := range children {
+			level = append(level, child.ID)
+		}
+		ids = append(ids, level...)
+	}
+	return ids, nil
+}
+
+// writeFolder writes a folder as a JSON response.
+func writeFolder(w http.ResponseWriter, status int, folder models.Folder) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(folder)
+}
+
+// CreateFolder creates a new folder below parent_id, or at the root.
+func CreateFolder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name        string `json:"name"`
+		ParentID    string `json:"parent_id"`
+		WorkspaceID string `json:"workspace_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateName("name", req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authorizeWorkspace(ctx, w, r, req.WorkspaceID, true) {
+		return
+	}
+	if exists, err := folderInWorkspace(ctx, req.ParentID, req.WorkspaceID); err != nil {
+		http.Error(w, "Failed to fetch folder from database", http.StatusInternalServerError)
+		return
+	} else if !exists {
+		http.Error(w, "Parent folder not found", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	folder := models.Folder{
+		ID:          uuid.New().String(),
+		Name:        req.Name,
+		ParentID:    req.ParentID,
+		WorkspaceID: req.WorkspaceID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if _, err := database.FolderCollection.InsertOne(ctx, folder); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			http.Error(w, "A folder with this name already exists here", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Could not create folder", http.StatusInternalServerError)
+		log.Printf("Error creating folder: %v", err)
+		return
+	}
+	audit.Log(currentUser(r), "folder.create", folder.ID, map[string]string{"name": folder.Name, "parent_id": folder.ParentID})
+
+	writeFolder(w, http.StatusCreated, folder)
+}
+
+// updateFolder applies set to a folder and writes the updated folder, mapping
+// sibling name clashes to 409 Conflict.
+func updateFolder(ctx context.Context, w http.ResponseWriter, folderID string, set bson.D) (models.Folder, bool) {
+	set = append(set, bson.E{Key: "updated_at", Value: time.Now()})
+
+	var folder models.Folder
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := database.FolderCollection.FindOneAndUpdate(ctx, bson.M{"_id": folderID}, bson.D{{Key: "$set", Value: set}}, opts).Decode(&folder)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return folder, false
+	case mongo.IsDuplicateKeyError(err):
+		http.Error(w, "A folder with this name already exists here", http.StatusConflict)
+		return folder, false
+	case err != nil:
+		http.Error(w, "Failed to update folder", http.StatusInternalServerError)
+		log.Printf("Error updating folder %s: %v", folderID, err)
+		return folder, false
+	}
+	writeFolder(w, http.StatusOK, folder)
+	return folder, true
+}
+
+// RenameFolder changes the name of a folder.
+func RenameFolder(w http.ResponseWriter, r *http.Request) {
+	folderID := chi.URLParam(r, "id")
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateName("name", req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, ok := authorizeFolder(ctx, w, r, folderID, true); !ok {
+		return
+	}
+	if _, ok := updateFolder(ctx, w, folderID, bson.D{{Key: "name", Value: req.Name}}); ok {
+		audit.Log(currentUser(r), "folder.rename", folderID, map[string]string{"name": req.Name})
+	}
+}
+
+// MoveFolder moves a folder, with everything in it, below another folder or
+// to the root.
+func MoveFolder(w http.ResponseWriter, r *http.Request) {
+	folderID := chi.URLParam(r, "id")
+
+	var req struct {
+		ParentID string `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	folder, ok := authorizeFolder(ctx, w, r, folderID, true)
+	if !ok {
+		return
+	}
+
+	if req.ParentID != "" {
+		// The new parent must exist in the same workspace and must not be the
+		// folder itself or one of its descendants, otherwise the tree would
+		// contain a cycle.
+		if exists, err := folderInWorkspace(ctx, req.ParentID, folder.WorkspaceID); err != nil {
+			http.Error(w, "Failed to fetch folder from database", http.StatusInternalServerError)
+			return
+		} else if !exists {
+			http.Error(w, "Parent folder not found", http.StatusBadRequest)
+			return
+		}
+		path, err := folderBreadcrumbs(ctx, req.ParentID)
+		if err != nil {
+			http.Error(w, "Failed to fetch folder from database", http.StatusInternalServerError)
+			return
+		}
+		for _, crumb := range path {
+			if crumb.ID == folderID {
+				http.Error(w, "A folder cannot be moved into itself or one of its subfolders", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	if _, ok := updateFolder(ctx, w, folderID, bson.D{{Key: "parent_id", Value: req.ParentID}}); ok {
+		audit.Log(currentUser(r), "folder.move", folderID, map[string]string{"parent_id": req.ParentID})
+	}
+}
+
+// DeleteFolder deletes a folder. Unless `recursive=true` is given, folders
+// that still contain files or subfolders are rejected with 409 Conflict.
+// The files are moved to the trash; restoring one puts it back in the root
+// folder since its folder is gone.
+func DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	folderID := chi.URLParam(r, "id")
+	recursive, _ := strconv.ParseBool(r.URL.Query().Get("recursive"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, ok := authorizeFolder(ctx, w, r, folderID, true); !ok {
+		return
+	}
+
+	folderIDs, err := descendantFolderIDs(ctx, folderID)
+	if err != nil {
+		http.Error(w, "Failed to fetch folders from database", http.StatusInternalServerError)
+		log.Printf("Error collecting subfolders of %s: %v", folderID, err)
+		return
+	}
+
+	var files []models.File
+	cursor, err := database.FileCollection.Find(ctx, bson.M{"folder_id": bson.M{"$in": folderIDs}, "deleted_at": nil})
+	if err == nil {
+		err = cursor.All(ctx, &files)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch files from database", http.StatusInternalServerError)
+		log.Printf("Error collecting files in folder %s: %v", folderID, err)
+		return
+	}
+
+	if !recursive && (len(folderIDs) > 1 || len(files) > 0) {
+		http.Error(w, "Folder is not empty", http.StatusConflict)
+		return
+	}
+
+	// Trash the files first so a failure never leaves live files in a
+	// folder that no longer exists.
+	if len(files) > 0 {
+		if err := trashFiles(ctx, currentUser(r), bson.M{"folder_id": bson.M{"$in": folderIDs}}); err != nil {
+			http.Error(w, "Failed to delete files", http.StatusInternalServerError)
+			log.Printf("Error trashing files in folder %s: %v", folderID, err)
+			return
+		}
+	}
+	if _, err := database.FolderCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": folderIDs}}); err != nil {
+		http.Error(w, "Failed to delete folder", http.StatusInternalServerError)
+		log.Printf("Error deleting folder %s: %v", folderID, err)
+		return
+	}
+	audit.Log(currentUser(r), "folder.delete", folderID, map[string]string{
+		"folders": strconv.Itoa(len(folderIDs)),
+		"files":   strconv.Itoa(len(files)),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
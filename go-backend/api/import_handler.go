@@ -0,0 +1,280 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"file-hub-go/audit"
+	"file-hub-go/config"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	errDeniedAddress    = errors.New("the URL points to an address that imports are not allowed to reach")
+	errTooManyRedirects = errors.New("the URL redirects too many times")
+	errRedirectScheme   = errors.New("the URL redirects to a scheme other than http or https")
+)
+
+// ImportRequest is the body of the URL import endpoint.
+type ImportRequest struct {
+	URL         string                 `json:"url"`
+	Name        string                 `json:"name"` // Optional, defaults to the name the server gives
+	WorkspaceID string                 `json:"workspace_id"`
+	FolderID    string                 `json:"folder_id"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+// importError is a failed import with the HTTP status to report for it.
+type importError struct {
+	status int
+	msg    string
+}
+
+func (e importError) Error() string { return e.msg }
+
+// deniedAddress reports whether imports may not connect to ip.
+func deniedAddress(ip net.IP) bool {
+	for _, network := range config.AppConfig.ImportDeniedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// importClient returns an HTTP client for fetching imports. The address is
+// checked when each connection is made, after DNS resolution, so a host
+// name cannot be pointed at a denied address between a check and the
+// request. Proxies from the environment are not used since they would
+// connect on our behalf.
+func importClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || deniedAddress(ip) {
+				return errDeniedAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: config.AppConfig.ImportTimeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > config.AppConfig.ImportMaxRedirects {
+				return errTooManyRedirects
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errRedirectScheme
+			}
+			return nil
+		},
+	}
+}
+
+// importFilename picks the name for an imported file: the name the server
+// suggests, or else the last segment of the final URL.
+func importFilename(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := path.Base(strings.ReplaceAll(params["filename"], "\\", "/")); validateName("name", name) == nil {
+			return name
+		}
+	}
+	if name, err := url.PathUnescape(path.Base(resp.Request.URL.Path)); err == nil && validateName("name", name) == nil {
+		return name
+	}
+	return "download"
+}
+
+// fetchImport downloads rawURL into a temporary file. The caller removes
+// the file. Failures of the remote side are returned as importError.
+func fetchImport(ctx context.Context, rawURL string) (*os.File, string, string, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", "", 0, importError{http.StatusBadRequest, "invalid URL"}
+	}
+	resp, err := importClient().Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		switch {
+		case errors.Is(err, errDeniedAddress):
+			return nil, "", "", 0, importError{http.StatusForbidden, errDeniedAddress.Error()}
+		case errors.Is(err, errTooManyRedirects), errors.Is(err, errRedirectScheme):
+			return nil, "", "", 0, importError{http.StatusBadGateway, errors.Unwrap(err).Error()}
+		case errors.As(err, &urlErr) && urlErr.Timeout():
+			return nil, "", "", 0, importError{http.StatusGatewayTimeout, "the server did not respond in time"}
+		}
+		log.Printf("Error fetching import %s: %v", req.URL.Redacted(), err)
+		return nil, "", "", 0, importError{http.StatusBadGateway, "the URL could not be fetched"}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", 0, importError{http.StatusBadGateway, fmt.Sprintf("the server answered %s", resp.Status)}
+	}
+	maxSize := config.AppConfig.ImportMaxSize
+	tooLarge := importError{http.StatusRequestEntityTooLarge, fmt.Sprintf("the file is larger than the %dMB import limit", maxSize/1024/1024)}
+	if resp.ContentLength > maxSize {
+		return nil, "", "", 0, tooLarge
+	}
+
+	tmp, err := os.CreateTemp("", "filehub-import-*")
+	if err != nil {
+		return nil, "", "", 0, err
+	}
+	// Content-Length can be missing or wrong, so count what arrives.
+	size, err := io.Copy(tmp, io.LimitReader(resp.Body, maxSize+1))
+	if err == nil && size > maxSize {
+		err = tooLarge
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && urlErr.Timeout() || os.IsTimeout(err) {
+			err = importError{http.StatusGatewayTimeout, "the download did not finish in time"}
+		} else if _, ok := err.(importError); !ok {
+			log.Printf("Error downloading import %s: %v", req.URL.Redacted(), err)
+			err = importError{http.StatusBadGateway, "the download was interrupted"}
+		}
+		return nil, "", "", 0, err
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return tmp, importFilename(resp), contentType, size, nil
+}
+
+// ImportFile saves a file fetched by the server from a URL. The request is
+// checked up front; the download runs as a background job whose result
+// carries the ID of the new file.
+func ImportFile(w http.ResponseWriter, r *http.Request) {
+	var req ImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	source, err := url.Parse(req.URL)
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Host == "" {
+		http.Error(w, "url must be an absolute http or https URL", http.StatusBadRequest)
+		return
+	}
+	if req.Name != "" {
+		if err := validateName("name", req.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if !authorizeWorkspace(ctx, w, r, req.WorkspaceID, true) {
+		return
+	}
+	if exists, err := folderInWorkspace(ctx, req.FolderID, req.WorkspaceID); err != nil {
+		http.Error(w, "Failed to fetch folder from database", http.StatusInternalServerError)
+		return
+	} else if !exists {
+		http.Error(w, "Folder not found", http.StatusBadRequest)
+		return
+	}
+	schema, err := loadMetadataSchema(ctx, req.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch metadata schema from database", http.StatusInternalServerError)
+		return
+	}
+	if req.Metadata == nil {
+		req.Metadata = map[string]interface{}{}
+	}
+	metadata, err := validateMetadata(schema, req.Metadata)
+	if err == nil {
+		err = checkRequiredMetadata(schema, metadata)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Credentials in the URL are used for the download but never stored.
+	provenance := source.Redacted()
+	username := currentUser(r)
+	job, err := startJob(username, "import", 1, func(ctx context.Context, report func([]models.JobResult)) error {
+		tmp, name, contentType, size, err := fetchImport(ctx, source.String())
+		var failed importError
+		if errors.As(err, &failed) {
+			report([]models.JobResult{{ID: provenance, Status: failed.status, Error: failed.msg}})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if req.Name != "" {
+			name = req.Name
+		}
+
+		fileType, _, _ := detectType(tmp, name, contentType)
+		violation, err := checkUploadPolicy(ctx, req.WorkspaceID, name, fileType)
+		if err != nil {
+			return err
+		}
+		if violation != nil {
+			report([]models.JobResult{{ID: provenance, Status: http.StatusUnsupportedMediaType, Error: violation.Message}})
+			return nil
+		}
+
+		blob, err := storeBlob(ctx, tmp, size, name, contentType, blobOwner{username, req.WorkspaceID})
+		var infected infectedError
+		if errors.As(err, &infected) {
+			report([]models.JobResult{{ID: provenance, Status: http.StatusUnprocessableEntity, Error: infected.Error()}})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		newFile := newUploadedFile(blob, name, username)
+		newFile.WorkspaceID = req.WorkspaceID
+		newFile.FolderID = req.FolderID
+		newFile.Metadata = metadata
+		newFile.SourceURL = provenance
+		if _, err := database.FileCollection.InsertOne(ctx, newFile); err != nil {
+			return err
+		}
+		queueScan()
+		queueThumbnails(blob)
+		audit.Log(username, "file.import", newFile.ID, map[string]string{
+			"filename": newFile.OriginalFilename,
+			"hash":     newFile.Hash,
+			"url":      provenance,
+		})
+		report([]models.JobResult{{ID: provenance, Status: http.StatusCreated, FileID: newFile.ID}})
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Could not start import job", http.StatusInternalServerError)
+		log.Printf("Error creating import job: %v", err)
+		return
+	}
+	writeJobAccepted(w, job)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"file-hub-go/config"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setImportConfig sets the import limits for a test. Loopback is denied by
+// default, so the test servers are only reachable with the denylist
+// overridden.
+func setImportConfig(t *testing.T, denied ...string) {
+	t.Helper()
+	saved := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = saved })
+	config.AppConfig.ImportMaxSize = 1024
+	config.AppConfig.ImportTimeout = 5 * time.Second
+	config.AppConfig.ImportMaxRedirects = 2
+	config.AppConfig.ImportDeniedNetworks = nil
+	for _, cidr := range denied {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.AppConfig.ImportDeniedNetworks = append(config.AppConfig.ImportDeniedNetworks, network)
+	}
+}
+
+// fetchImportError fetches url and returns the importError it fails with.
+func fetchImportError(t *testing.T, url string) importError {
+	t.Helper()
+	tmp, _, _, _, err := fetchImport(context.Background(), url)
+	if tmp != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	var failed importError
+	if !errors.As(err, &failed) {
+		t.Fatalf("fetchImport(%s) = %v, want an importError", url, err)
+	}
+	return failed
+}
+
+func TestFetchImport(t *testing.T) {
+	setImportConfig(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="report.txt"`)
+		io.WriteString(w, "hello")
+	}))
+	defer server.Close()
+
+	tmp, name, contentType, size, err := fetchImport(context.Background(), server.URL+"/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	content, _ := os.ReadFile(tmp.Name())
+	if name != "report.txt" || contentType != "text/plain" || size != 5 || string(content) != "hello" {
+		t.Errorf("got %q, %q, %d bytes %q; want report.txt, text/plain, 5 bytes hello", name, contentType, size, content)
+	}
+}
+
+func TestFetchImportSizeLimit(t *testing.T) {
+	setImportConfig(t)
+	body := strings.Repeat("x", 2048)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			// Flushing first sends the body without a Content-Length.
+			w.(http.Flusher).Flush()
+		}
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/sized", "/chunked"} {
+		if failed := fetchImportError(t, server.URL+path); failed.status != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: status = %d, want %d", path, failed.status, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
+
+func TestFetchImportRedirects(t *testing.T) {
+	setImportConfig(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/ftp":
+			http.Redirect(w, r, "ftp://example.com/file", http.StatusFound)
+		case "/twice":
+			http.Redirect(w, r, "/once", http.StatusFound)
+		case "/once":
+			http.Redirect(w, r, "/file", http.StatusFound)
+		default:
+			io.WriteString(w, "content")
+		}
+	}))
+	defer server.Close()
+
+	tmp, _, _, _, err := fetchImport(context.Background(), server.URL+"/twice")
+	if err != nil {
+		t.Errorf("following 2 redirects: %v", err)
+	} else {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	for path, want := range map[string]error{"/loop": errTooManyRedirects, "/ftp": errRedirectScheme} {
+		failed := fetchImportError(t, server.URL+path)
+		if failed.status != http.StatusBadGateway || failed.msg != want.Error() {
+			t.Errorf("%s: got %d %q, want %d %q", path, failed.status, failed.msg, http.StatusBadGateway, want)
+		}
+	}
+}
+
+func TestFetchImportDeniedAddress(t *testing.T) {
+	setImportConfig(t, "127.0.0.0/8", "::1/128")
+	reached := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer server.Close()
+
+	// A host name is resolved before the address is checked.
+	url := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	failed := fetchImportError(t, url)
+	if failed.status != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", failed.status, http.StatusForbidden)
+	}
+	if reached {
+		t.Error("the denied server was reached")
+	}
+}
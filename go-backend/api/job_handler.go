@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxJobDuration bounds how long a background job may run.
+const maxJobDuration = time.Hour
+
+// startJob records a running job and calls work in the background. work
+// reports the results of items as it completes them; the job is finished
+// when work returns, and marked failed if it returns an error.
+func startJob(username, operation string, total int, work func(ctx context.Context, report func([]models.JobResult)) error) (models.Job, error) {
+	now := time.Now()
+	job := models.Job{
+		ID:        uuid.New().String(),
+		Owner:     username,
+		Operation: operation,
+		Status:    models.JobRunning,
+		Total:     total,
+		Results:   []models.JobResult{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := database.JobCollection.InsertOne(ctx, job); err != nil {
+		return job, err
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), maxJobDuration)
+		defer cancel()
+
+		report := func(results []models.JobResult) {
+			failed := 0
+			for _, result := range results {
+				if result.Status >= http.StatusBadRequest {
+					failed++
+				}
+			}
+			update := bson.M{
+				"$push": bson.M{"results": bson.M{"$each": results}},
+				"$inc":  bson.M{"done": len(results), "failed": failed},
+				"$set":  bson.M{"updated_at": time.Now()},
+			}
+			if _, err := database.JobCollection.UpdateOne(ctx, bson.M{"_id": job.ID}, update); err != nil {
+				log.Printf("Error recording progress of job %s: %v", job.ID, err)
+			}
+		}
+
+		set := bson.M{"status": models.JobDone}
+		if err := work(ctx, report); err != nil {
+			log.Printf("Job %s failed: %v", job.ID, err)
+			set = bson.M{"status": models.JobFailed, "error": "The job could not be completed"}
+		}
+		now := time.Now()
+		set["updated_at"] = now
+		set["finished_at"] = now
+
+		// Record the outcome even if the job ran out of time.
+		finishCtx, finishCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer finishCancel()
+		if _, err := database.JobCollection.UpdateOne(finishCtx, bson.M{"_id": job.ID}, bson.M{"$set": set}); err != nil {
+			log.Printf("Error finishing job %s: %v", job.ID, err)
+		}
+	}()
+	return job, nil
+}
+
+// writeJobAccepted answers a request that was turned into a background job.
+func writeJobAccepted(w http.ResponseWriter, job models.Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/jobs/"+job.ID+"/")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetJob returns the progress and results of one of the caller's jobs.
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "id")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var job models.Job
+	err := database.JobCollection.FindOne(ctx, bson.M{"_id": jobID, "owner": currentUser(r)}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch job from database", http.StatusInternalServerError)
+		log.Printf("Error fetching job %s: %v", jobID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"file-hub-go/audit"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultWorkspaceAlias stands for the default workspace in URLs, where an
+// empty path segment is not possible.
+const defaultWorkspaceAlias = "default"
+
+// metadataPrefix marks custom metadata in upload form fields and in GetFiles
+// query parameters, e.g. `metadata.project=ACME`.
+const metadataPrefix = "metadata."
+
+const (
+	maxMetadataFields      = 50
+	maxMetadataValueLength = 1024
+)
+
+// Metadata keys end up in MongoDB field paths, so dots and dollar signs
+// must never get through.
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Comparison operators accepted in metadata filters, e.g. `metadata.amount=gte:100`.
+var metadataOperators = map[string]string{
+	"eq":  "$eq",
+	"ne":  "$ne",
+	"gt":  "$gt",
+	"gte": "$gte",
+	"lt":  "$lt",
+	"lte": "$lte",
+}
+
+// workspaceFromURL returns the workspace ID in the {id} URL parameter.
+func workspaceFromURL(r *http.Request) string {
+	id := chi.URLParam(r, "id")
+	if id == defaultWorkspaceAlias {
+		return ""
+	}
+	return id
+}
+
+// loadMetadataSchema returns the metadata schema of a workspace, or an
+// empty schema if none has been defined.
+func loadMetadataSchema(ctx context.Context, workspaceID string) (models.MetadataSchema, error) {
+	schema := models.MetadataSchema{WorkspaceID: workspaceID, Fields: []models.MetadataField{}}
+	err := database.MetadataSchemaCollection.FindOne(ctx, bson.M{"_id": workspaceID}).Decode(&schema)
+	if err == mongo.ErrNoDocuments {
+		return schema, nil
+	}
+	return schema, err
+}
+
+// schemaField looks up a field of a schema by name.
+func schemaField(schema models.MetadataSchema, name string) (models.MetadataField, bool) {
+	for _, field := range schema.Fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return models.MetadataField{}, false
+}
+
+// parseMetadataValue converts a value from a form field or JSON body into
+// the type the schema gives the key. Keys without a schema field are strings.
+func parseMetadataValue(schema models.MetadataSchema, key string, raw interface{}) (interface{}, error) {
+	var str string
+	switch v := raw.(type) {
+	case string:
+		str = v
+	case float64:
+		str = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		str = strconv.FormatBool(v)
+	default:
+		return nil, fmt.Errorf("metadata %q must be a string or a number", key)
+	}
+	if len(str) > maxMetadataValueLength {
+		return nil, fmt.Errorf("metadata %q must be at most %d characters", key, maxMetadataValueLength)
+	}
+
+	field, ok := schemaField(schema, key)
+	if !ok {
+		return str, nil
+	}
+	switch field.Type {
+	case models.MetadataNumber:
+		n, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q must be a number", key)
+		}
+		return n, nil
+	case models.MetadataDate:
+		str = strings.TrimSpace(str)
+		if t, err := time.Parse(time.RFC3339, str); err == nil {
+			return t.UTC(), nil
+		}
+		t, err := time.Parse("2006-01-02", str)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q must be a date (YYYY-MM-DD or RFC 3339)", key)
+		}
+		return t, nil
+	case models.MetadataEnum:
+		for _, allowed := range field.Values {
+			if str == allowed {
+				return str, nil
+			}
+		}
+		return nil, fmt.Errorf("metadata %q must be one of %s", key, strings.Join(field.Values, ", "))
+	default:
+		return str, nil
+	}
+}
+
+// validateMetadata checks the keys of a metadata map and converts its values
+// according to the schema.
+func validateMetadata(schema models.MetadataSchema, raw map[string]interface{}) (map[string]interface{}, error) {
+	if len(raw) > maxMetadataFields {
+		return nil, fmt.Errorf("a file can have at most %d metadata fields", maxMetadataFields)
+	}
+	metadata := map[string]interface{}{}
+	for key, value := range raw {
+		if !metadataKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("metadata key %q may only contain letters, digits, '-' and '_'", key)
+		}
+		parsed, err := parseMetadataValue(schema, key, value)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = parsed
+	}
+	return metadata, nil
+}
+
+// checkRequiredMetadata reports the first required schema field missing
+// from metadata.
+func checkRequiredMetadata(schema models.MetadataSchema, metadata map[string]interface{}) error {
+	for _, field := range schema.Fields {
+		if _, ok := metadata[field.Name]; field.Required && !ok {
+			return fmt.Errorf("metadata %q is required", field.Name)
+		}
+	}
+	return nil
+}
+
+// metadataFromForm collects the `metadata.<key>` fields of an upload form.
+func metadataFromForm(form url.Values) map[string]interface{} {
+	raw := map[string]interface{}{}
+	for name, values := range form {
+		if key := strings.TrimPrefix(name, metadataPrefix); key != name && len(values) > 0 {
+			raw[key] = values[0]
+		}
+	}
+	return raw
+}
+
+// buildMetadataFilter turns `metadata.<key>=[op:]value` query parameters into
+// a MongoDB filter. Values are compared using the type the schema gives the
+// key, so numbers and dates compare as such rather than as strings.
+func buildMetadataFilter(params url.Values, schema models.MetadataSchema) (bson.D, error) {
+	filter := bson.D{}
+	for name, values := range params {
+		key := strings.TrimPrefix(name, metadataPrefix)
+		if key == name {
+			continue
+		}
+		if !metadataKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid metadata key %q", key)
+		}
+		for _, value := range values {
+			op := "$eq"
+			if prefix, rest, ok := strings.Cut(value, ":"); ok {
+				if mongoOp, known := metadataOperators[prefix]; known {
+					op, value = mongoOp, rest
+				}
+			}
+			parsed, err := parseMetadataValue(schema, key, value)
+			if err != nil {
+				return nil, err
+			}
+			filter = append(filter, bson.E{Key: "metadata." + key, Value: bson.D{{Key: op, Value: parsed}}})
+		}
+	}
+	return filter, nil
+}
+
+// GetMetadataSchema returns the metadata schema of a workspace.
+func GetMetadataSchema(w http.ResponseWriter, r *http.Request) {
+	workspaceID := workspaceFromURL(r)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authorizeWorkspace(ctx, w, r, workspaceID, false) {
+		return
+	}
+	schema, err := loadMetadataSchema(ctx, workspaceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch metadata schema from database", http.StatusInternalServerError)
+		log.Printf("Error fetching metadata schema of workspace %s: %v", workspaceID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schema)
+}
+
+// validateSchemaFields checks a schema submitted by a client.
+func validateSchemaFields(fields []models.MetadataField) error {
+	if len(fields) > maxMetadataFields {
+		return fmt.Errorf("a schema can have at most %d fields", maxMetadataFields)
+	}
+	seen := map[string]bool{}
+	for _, field := range fields {
+		if !metadataKeyPattern.MatchString(field.Name) {
+			return fmt.Errorf("field name %q may only contain letters, digits, '-' and '_'", field.Name)
+		}
+		if seen[field.Name] {
+			return fmt.Errorf("field %q is defined more than once", field.Name)
+		}
+		seen[field.Name] = true
+
+		switch field.Type {
+		case models.MetadataString, models.MetadataNumber, models.MetadataDate:
+			if len(field.Values) > 0 {
+				return fmt.Errorf("field %q: values are only allowed for enum fields", field.Name)
+			}
+		case models.MetadataEnum:
+			if len(field.Values) == 0 {
+				return fmt.Errorf("field %q: enum fields need at least one value", field.Name)
+			}
+		default:
+			return fmt.Errorf("field %q: type must be string, number, date or enum", field.Name)
+		}
+	}
+	return nil
+}
+
+// SetMetadataSchema replaces the metadata schema of a workspace. Existing
+// files are not revalidated; the schema applies to later uploads and edits.
+// A required field blocks every upload without it, so only the owner may
+// change the schema, and only admins for the default workspace.
+func SetMetadataSchema(w http.ResponseWriter, r *http.Request) {
+	workspaceID := workspaceFromURL(r)
+
+	var req struct {
+		Fields []models.MetadataField `json:"fields"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Fields == nil {
+		req.Fields = []models.MetadataField{}
+	}
+	if err := validateSchemaFields(req.Fields); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authorizeWorkspaceOwner(ctx, w, r, workspaceID, "metadata schema") {
+		return
+	}
+
+	schema := models.MetadataSchema{
+		WorkspaceID: workspaceID,
+		Fields:      req.Fields,
+		UpdatedAt:   time.Now(),
+	}
+	opts := options.Replace().SetUpsert(true)
+	if _, err := database.MetadataSchemaCollection.ReplaceOne(ctx, bson.M{"_id": workspaceID}, schema, opts); err != nil {
+		http.Error(w, "Failed to save metadata schema", http.StatusInternalServerError)
+		log.Printf("Error saving metadata schema of workspace %s: %v", workspaceID, err)
+		return
+	}
+	audit.Log(currentUser(r), "workspace.schema", workspaceID, map[string]string{"fields": strconv.Itoa(len(schema.Fields))})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schema)
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// sortFields maps the `sort` query parameter to the field it sorts by.
+var sortFields = map[string]string{
+	"name": "original_filename",
+	"size": "size",
+	"type": "file_type",
+	"date": "uploaded_at",
+}
+
+// sortRelevance orders content searches by their text score. It is not a
+// field, so its pages are addressed by offset rather than by keyset.
+const sortRelevance = "relevance"
+
+// pageCursor is the position after the last item of a page. It is handed to
+// clients as an opaque base64 string and records the sort it was made for,
+// so it cannot be replayed against a different ordering.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// pageRequest holds the parsed paging and sorting parameters.
+type pageRequest struct {
+	Sort  string
+	Desc  bool
+	Limit int64
+	Count bool
+	After *pageCursor
+}
+
+// parsePageRequest reads `sort`, `order`, `limit`, `cursor` and `count`.
+// The default is newest first, matching the order of the unpaged listing, or
+// best match first for content searches.
+func parsePageRequest(params url.Values, contentSearch bool) (pageRequest, error) {
+	page := pageRequest{Sort: "date", Desc: true, Limit: defaultPageSize}
+	if contentSearch {
+		page.Sort = sortRelevance
+	}
+
+	if s := params.Get("sort"); s != "" {
+		if _, ok := sortFields[s]; !ok && !(s == sortRelevance && contentSearch) {
+			return page, fmt.Errorf("sort must be one of name, size, type or date, or relevance for content searches")
+		}
+		page.Sort = s
+		// Names and types read naturally A-Z, sizes and dates largest/newest first.
+		page.Desc = s == "size" || s == "date" || s == sortRelevance
+	}
+	switch params.Get("order") {
+	case "":
+	case "asc", "desc":
+		if page.Sort == sortRelevance {
+			return page, fmt.Errorf("order cannot be changed for relevance")
+		}
+		page.Desc = params.Get("order") == "desc"
+	default:
+		return page, fmt.Errorf("order must be asc or desc")
+	}
+
+	if l := params.Get("limit"); l != "" {
+		limit, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return page, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		page.Limit = limit
+	}
+	page.Count, _ = strconv.ParseBool(params.Get("count"))
+
+	if c := params.Get("cursor"); c != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(c)
+		var cursor pageCursor
+		if err == nil {
+			err = json.Unmarshal(raw, &cursor)
+		}
+		if err != nil {
+			return page, fmt.Errorf("invalid cursor")
+		}
+		if cursor.Sort != page.Sort || cursor.Order != page.order() {
+			return page, fmt.Errorf("cursor does not match the requested sort order")
+		}
+		page.After = &cursor
+	}
+	return page, nil
+}
+
+func (p pageRequest) order() string {
+	if p.Desc {
+		return "desc"
+	}
+	return "asc"
+}
+
+// findOptions returns the sort, limit and collation for a page. One extra
+// document is fetched to find out whether there is a next page. _id breaks
+// ties so that every document has a unique position.
+func (p pageRequest) findOptions() *options.FindOptions {
+	if p.Sort == sortRelevance {
+		score := bson.D{{Key: "$meta", Value: "textScore"}}
+		return options.Find().
+			SetProjection(bson.D{{Key: "score", Value: score}, {Key: "versions", Value: 0}}).
+			SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}}).
+			SetSkip(p.offset()).
+			SetLimit(p.Limit + 1)
+	}
+
+	direction := 1
+	if p.Desc {
+		direction = -1
+	}
+	// The extracted text can be large and is only needed for snippets;
+	// the version history is only needed by the versions endpoints.
+	opts := options.Find().
+		SetProjection(bson.D{{Key: "content", Value: 0}, {Key: "versions", Value: 0}}).
+		SetSort(bson.D{{Key: sortFields[p.Sort], Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(p.Limit + 1)
+	if p.Sort == "name" || p.Sort == "type" {
+		// Sort names case-insensitively; the cursor comparison below uses
+		// the same collation so pages line up.
+		opts.SetCollation(&options.Collation{Locale: "en", Strength: 2})
+	}
+	return opts
+}
+
+// offset returns the number of results before the page for relevance sorts.
+func (p pageRequest) offset() int64 {
+	if p.After == nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(p.After.Value, 10, 64)
+	return n
+}
+
+// cursorValue converts the stored cursor value back to the type of the
+// sort field.
+func (p pageRequest) cursorValue() (interface{}, error) {
+	switch p.Sort {
+	case "size":
+		return strconv.ParseInt(p.After.Value, 10, 64)
+	case "date":
+		return time.Parse(time.RFC3339Nano, p.After.Value)
+	default:
+		return p.After.Value, nil
+	}
+}
+
+// afterFilter restricts a query to the documents after the cursor. It
+// returns nil for the first page.
+func (p pageRequest) afterFilter() (bson.D, error) {
+	if p.After == nil || p.Sort == sortRelevance {
+		return nil, nil
+	}
+	value, err := p.cursorValue()
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	op := "$gt"
+	if p.Desc {
+		op = "$lt"
+	}
+	field := sortFields[p.Sort]
+	return bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: field, Value: bson.D{{Key: op, Value: value}}}},
+		bson.D{{Key: field, Value: value}, {Key: "_id", Value: bson.D{{Key: op, Value: p.After.ID}}}},
+	}}}, nil
+}
+
+// nextCursor encodes the position after the given value and ID.
+func (p pageRequest) nextCursor(value interface{}, id string) string {
+	cursor := pageCursor{Sort: p.Sort, Order: p.order(), ID: id}
+	switch v := value.(type) {
+	case time.Time:
+		cursor.Value = v.UTC().Format(time.RFC3339Nano)
+	default:
+		cursor.Value = fmt.Sprint(v)
+	}
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// nextLink returns the URL of the next page: the current URL with the
+// cursor replaced.
+func nextLink(u *url.URL, cursor string) string {
+	next := *u
+	query := next.Query()
+	query.Set("cursor", cursor)
+	next.RawQuery = query.Encode()
+	return next.RequestURI()
+}
@@ -0,0 +1,477 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"file-hub-go/audit"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"file-hub-go/sniff"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxPolicyRules = 200
+	// Violation scans list at most this many files per request.
+	defaultPolicyViolations = 100
+	maxPolicyViolations     = 1000
+)
+
+// Reasons a file violates an upload policy.
+const (
+	reasonDeniedType          = "denied_type"
+	reasonDeniedExtension     = "denied_extension"
+	reasonDeniedPattern       = "denied_pattern"
+	reasonTypeNotAllowed      = "type_not_allowed"
+	reasonExtensionNotAllowed = "extension_not_allowed"
+)
+
+// defaultUploadPolicy is the global policy until an admin sets one. It keeps
+// executables and scripts out.
+func defaultUploadPolicy() models.UploadPolicy {
+	return models.UploadPolicy{
+		ID:         models.GlobalPolicyID,
+		AllowTypes: []string{},
+		DenyTypes: []string{
+			"application/vnd.microsoft.portable-executable",
+			"application/x-executable",
+			"application/x-mach-binary",
+			"application/x-msi",
+			"application/java-archive",
+			"application/vnd.android.package-archive",
+			"text/x-shellscript",
+			"text/x-script",
+		},
+		AllowExtensions: []string{},
+		DenyExtensions: []string{
+			".exe", ".dll", ".com", ".scr", ".msi", ".cpl", ".bat", ".cmd", ".ps1",
+			".vbs", ".vbe", ".jse", ".wsf", ".hta", ".sh", ".jar", ".apk",
+		},
+		DenyPatterns: []string{},
+	}
+}
+
+// PolicyViolation is the error for a file an upload policy rejects. It says
+// which policy and which rule the file breaks.
+type PolicyViolation struct {
+	Message  string `json:"error"`
+	Policy   string `json:"policy"` // "global" or the workspace ID
+	Reason   string `json:"reason"` // One of the reason constants
+	Rule     string `json:"rule,omitempty"`
+	Filename string `json:"filename"`
+	FileType string `json:"file_type"`
+}
+
+func (v *PolicyViolation) Error() string { return v.Message }
+
+func typeMatches(rule, fileType string) bool {
+	fileType = sniff.Normalize(fileType)
+	if family, ok := strings.CutSuffix(rule, "/*"); ok {
+		return strings.HasPrefix(fileType, family+"/")
+	}
+	return fileType == rule
+}
+
+func extensionMatches(rule, filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), rule)
+}
+
+func patternMatches(rule, filename string) bool {
+	matched, _ := regexp.MatchString("(?i)"+globPattern(rule), filename)
+	return matched
+}
+
+// policyViolation returns how a file breaks policy, or nil if it does not.
+func policyViolation(policy models.UploadPolicy, filename, fileType string) *PolicyViolation {
+	violation := func(reason, rule, message string) *PolicyViolation {
+		return &PolicyViolation{Message: message, Policy: policy.ID, Reason: reason, Rule: rule, Filename: filename, FileType: fileType}
+	}
+	for _, rule := range policy.DenyTypes {
+		if typeMatches(rule, fileType) {
+			return violation(reasonDeniedType, rule, fmt.Sprintf("Files of type %s are not allowed", fileType))
+		}
+	}
+	for _, rule := range policy.DenyExtensions {
+		if extensionMatches(rule, filename) {
+			return violation(reasonDeniedExtension, rule, fmt.Sprintf("Files ending in %s are not allowed", rule))
+		}
+	}
+	for _, rule := range policy.DenyPatterns {
+		if patternMatches(rule, filename) {
+			return violation(reasonDeniedPattern, rule, fmt.Sprintf("Files named like %s are not allowed", rule))
+		}
+	}
+	if len(policy.AllowTypes) > 0 && !slices.ContainsFunc(policy.AllowTypes, func(rule string) bool { return typeMatches(rule, fileType) }) {
+		return violation(reasonTypeNotAllowed, "", fmt.Sprintf("Files of type %s are not allowed", fileType))
+	}
+	if len(policy.AllowExtensions) > 0 && !slices.ContainsFunc(policy.AllowExtensions, func(rule string) bool { return extensionMatches(rule, filename) }) {
+		return violation(reasonExtensionNotAllowed, "", "Files with this extension are not allowed")
+	}
+	return nil
+}
+
+// loadGlobalPolicy returns the global upload policy, or the default one if
+// no admin has set it.
+func loadGlobalPolicy(ctx context.Context) (models.UploadPolicy, error) {
+	policy := defaultUploadPolicy()
+	err := database.UploadPolicyCollection.FindOne(ctx, bson.M{"_id": models.GlobalPolicyID}).Decode(&policy)
+	if err == mongo.ErrNoDocuments {
+		return defaultUploadPolicy(), nil
+	}
+	return policy, err
+}
+
+// loadWorkspacePolicy returns the upload policy of a workspace, which has
+// no rules if none has been set.
+func loadWorkspacePolicy(ctx context.Context, workspaceID string) (models.UploadPolicy, error) {
+	policy := models.UploadPolicy{ID: workspaceID, AllowTypes: []string{}, DenyTypes: []string{}, AllowExtensions: []string{}, DenyExtensions: []string{}, DenyPatterns: []string{}}
+	err := database.UploadPolicyCollection.FindOne(ctx, bson.M{"_id": workspaceID}).Decode(&policy)
+	if err == mongo.ErrNoDocuments {
+		return policy, nil
+	}
+	return policy, err
+}
+
+// checkUploadPolicy checks a file about to be stored in a workspace against
+// the global policy and the workspace's own policy. fileType is the
+// detected type.
+func checkUploadPolicy(ctx context.Context, workspaceID, filename, fileType string) (*PolicyViolation, error) {
+	global, err := loadGlobalPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if v := policyViolation(global, filename, fileType); v != nil {
+		return v, nil
+	}
+	local, err := loadWorkspacePolicy(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	return policyViolation(local, filename, fileType), nil
+}
+
+// enforceUploadPolicy is checkUploadPolicy for handlers: it writes the
+// violation or error response and reports whether the file may be stored.
+func enforceUploadPolicy(ctx context.Context, w http.ResponseWriter, workspaceID, filename, fileType string) bool {
+	violation, err := checkUploadPolicy(ctx, workspaceID, filename, fileType)
+	if err != nil {
+		http.Error(w, "Failed to fetch upload policy from database", http.StatusInternalServerError)
+		log.Printf("Error fetching upload policy of workspace %s: %v", workspaceID, err)
+		return false
+	}
+	if violation != nil {
+		writePolicyViolation(w, violation)
+		return false
+	}
+	return true
+}
+
+// writePolicyViolation answers with the violation as a JSON error.
+func writePolicyViolation(w http.ResponseWriter, violation *PolicyViolation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnsupportedMediaType)
+	json.NewEncoder(w).Encode(violation)
+}
+
+// policyFilter matches the files that break a policy, or returns nil if
+// the policy has no rules. It mirrors policyViolation.
+func policyFilter(policy models.UploadPolicy) bson.M {
+	typeCond := func(rule string) bson.M {
+		pattern := "^" + regexp.QuoteMeta(rule) + `\s*(;.*)?$`
+		if family, ok := strings.CutSuffix(rule, "/*"); ok {
+			pattern = "^" + regexp.QuoteMeta(family) + "/"
+		}
+		return bson.M{"file_type": bson.M{"$regex": pattern, "$options": "i"}}
+	}
+	extensionCond := func(rule string) bson.M {
+		return bson.M{"original_filename": bson.M{"$regex": regexp.QuoteMeta(rule) + "$", "$options": "i"}}
+	}
+
+	conds := bson.A{}
+	for _, rule := range policy.DenyTypes {
+		conds = append(conds, typeCond(rule))
+	}
+	for _, rule := range policy.DenyExtensions {
+		conds = append(conds, extensionCond(rule))
+	}
+	for _, rule := range policy.DenyPatterns {
+		conds = append(conds, bson.M{"original_filename": bson.M{"$regex": globPattern(rule), "$options": "i"}})
+	}
+	if len(policy.AllowTypes) > 0 {
+		allowed := bson.A{}
+		for _, rule := range policy.AllowTypes {
+			allowed = append(allowed, typeCond(rule))
+		}
+		conds = append(conds, bson.M{"$nor": allowed})
+	}
+	if len(policy.AllowExtensions) > 0 {
+		allowed := bson.A{}
+		for _, rule := range policy.AllowExtensions {
+			allowed = append(allowed, extensionCond(rule))
+		}
+		conds = append(conds, bson.M{"$nor": allowed})
+	}
+	if len(conds) == 0 {
+		return nil
+	}
+	return bson.M{"$or": conds}
+}
+
+// normalizePolicy checks the rules of a policy submitted by a client and
+// lowercases them.
+func normalizePolicy(policy *models.UploadPolicy) error {
+	lists := []struct {
+		name  string
+		rules *[]string
+		check func(string) bool
+		hint  string
+	}{
+		{"allow_types", &policy.AllowTypes, isTypeRule, "a MIME type such as image/png or image/*"},
+		{"deny_types", &policy.DenyTypes, isTypeRule, "a MIME type such as image/png or image/*"},
+		{"allow_extensions", &policy.AllowExtensions, isExtensionRule, "an extension starting with a dot"},
+		{"deny_extensions", &policy.DenyExtensions, isExtensionRule, "an extension starting with a dot"},
+		{"deny_patterns", &policy.DenyPatterns, func(rule string) bool { return rule != "" && len(rule) <= maxFilenameLength }, "a filename pattern"},
+	}
+	for _, list := range lists {
+		if len(*list.rules) > maxPolicyRules {
+			return fmt.Errorf("%s can have at most %d rules", list.name, maxPolicyRules)
+		}
+		normalized := []string{}
+		for _, rule := range *list.rules {
+			rule = strings.ToLower(strings.TrimSpace(rule))
+			if !list.check(rule) {
+				return fmt.Errorf("%s: %q is not %s", list.name, rule, list.hint)
+			}
+			normalized = append(normalized, rule)
+		}
+		*list.rules = normalized
+	}
+	return nil
+}
+
+var typeRulePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9!#$&^_.+-]*/([a-z0-9][a-z0-9!#$&^_.+-]*|\*)$`)
+
+func isTypeRule(rule string) bool { return typeRulePattern.MatchString(rule) }
+
+func isExtensionRule(rule string) bool {
+	return len(rule) > 1 && len(rule) <= 32 && strings.HasPrefix(rule, ".") && !strings.ContainsAny(rule, `/\*`)
+}
+
+// writePolicy answers with an upload policy.
+func writePolicy(w http.ResponseWriter, policy models.UploadPolicy, err error) {
+	if err != nil {
+		http.Error(w, "Failed to fetch upload policy from database", http.StatusInternalServerError)
+		log.Printf("Error fetching upload policy %s: %v", policy.ID, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// savePolicy validates and stores an upload policy from the request body.
+func savePolicy(ctx context.Context, w http.ResponseWriter, r *http.Request, id string) {
+	var policy models.UploadPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := normalizePolicy(&policy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	policy.ID = id
+	policy.UpdatedAt = time.Now()
+	policy.UpdatedBy = currentUser(r)
+
+	opts := options.Replace().SetUpsert(true)
+	if _, err := database.UploadPolicyCollection.ReplaceOne(ctx, bson.M{"_id": id}, policy, opts); err != nil {
+		http.Error(w, "Failed to save upload policy", http.StatusInternalServerError)
+		log.Printf("Error saving upload policy %s: %v", id, err)
+		return
+	}
+	audit.Log(currentUser(r), "policy.update", id, map[string]string{
+		"deny_types":       strings.Join(policy.DenyTypes, ","),
+		"allow_types":      strings.Join(policy.AllowTypes, ","),
+		"deny_extensions":  strings.Join(policy.DenyExtensions, ","),
+		"allow_extensions": strings.Join(policy.AllowExtensions, ","),
+		"deny_patterns":    strings.Join(policy.DenyPatterns, ","),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// GetUploadPolicy returns the global upload policy.
+func GetUploadPolicy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	policy, err := loadGlobalPolicy(ctx)
+	writePolicy(w, policy, err)
+}
+
+// SetUploadPolicy replaces the global upload policy. Only admins can change
+// it. Files already stored are not affected; use GetPolicyViolations to find
+// the ones that break the new policy.
+func SetUploadPolicy(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(currentUser(r)) {
+		http.Error(w, "Only admins can change the global upload policy", http.StatusForbidden)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	savePolicy(ctx, w, r, models.GlobalPolicyID)
+}
+
+// GetWorkspaceUploadPolicy returns the upload policy of a workspace, which
+// applies on top of the global policy.
+func GetWorkspaceUploadPolicy(w http.ResponseWriter, r *http.Request) {
+	workspaceID := workspaceFromURL(r)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authorizeWorkspace(ctx, w, r, workspaceID, false) {
+		return
+	}
+	policy, err := loadWorkspacePolicy(ctx, workspaceID)
+	writePolicy(w, policy, err)
+}
+
+// SetWorkspaceUploadPolicy replaces the upload policy of a workspace. It can
+// only add restrictions; the global policy always applies as well. Since a
+// policy can block every upload, only the owner may change it, and only
+// admins for the default workspace, which everybody can edit.
+func SetWorkspaceUploadPolicy(w http.ResponseWriter, r *http.Request) {
+	workspaceID := workspaceFromURL(r)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authorizeWorkspaceOwner(ctx, w, r, workspaceID, "upload policy") {
+		return
+	}
+	savePolicy(ctx, w, r, workspaceID)
+}
+
+// PolicyViolationReport lists stored files that break the upload policies.
+type PolicyViolationReport struct {
+	Violations []FileViolation `json:"violations"`
+	Truncated  bool            `json:"truncated"`
+}
+
+// FileViolation is a stored file and how it breaks a policy.
+type FileViolation struct {
+	File      models.File      `json:"file"`
+	Violation *PolicyViolation `json:"violation"`
+}
+
+// GetPolicyViolations finds stored files that break the global policy or
+// their workspace's policy, e.g. after a policy was tightened. Only admins
+// can scan. Pass `workspace` to scan a single workspace (empty for the
+// default one) and `limit` for the number of files to return, newest first.
+func GetPolicyViolations(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(currentUser(r)) {
+		http.Error(w, "Only admins can scan for policy violations", http.StatusForbidden)
+		return
+	}
+	params := r.URL.Query()
+	limit := defaultPolicyViolations
+	if value := params.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPolicyViolations {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxPolicyViolations), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	global, err := loadGlobalPolicy(ctx)
+	if err != nil {
+		http.Error(w, "Failed to fetch upload policy from database", http.StatusInternalServerError)
+		log.Printf("Error fetching global upload policy: %v", err)
+		return
+	}
+	scope := bson.M{"_id": bson.M{"$ne": models.GlobalPolicyID}}
+	if params.Has("workspace") {
+		scope = bson.M{"_id": params.Get("workspace")}
+	}
+	var local []models.UploadPolicy
+	cursor, err := database.UploadPolicyCollection.Find(ctx, scope)
+	if err == nil {
+		err = cursor.All(ctx, &local)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch upload policies from database", http.StatusInternalServerError)
+		log.Printf("Error fetching upload policies: %v", err)
+		return
+	}
+
+	// A file violates if it breaks the global policy or the policy of its
+	// own workspace.
+	violating := bson.A{}
+	if cond := policyFilter(global); cond != nil {
+		violating = append(violating, cond)
+	}
+	byWorkspace := map[string]models.UploadPolicy{}
+	for _, policy := range local {
+		byWorkspace[policy.ID] = policy
+		if cond := policyFilter(policy); cond != nil {
+			violating = append(violating, bson.M{"workspace_id": emptyOr(policy.ID), "$and": bson.A{cond}})
+		}
+	}
+	report := PolicyViolationReport{Violations: []FileViolation{}}
+	if len(violating) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+	filter := bson.M{"deleted_at": nil, "$or": violating}
+	if params.Has("workspace") {
+		filter["workspace_id"] = emptyOr(params.Get("workspace"))
+	}
+
+	var files []models.File
+	opts := options.Find().
+		SetProjection(bson.M{"content": 0, "versions": 0}).
+		SetSort(bson.D{{Key: "uploaded_at", Value: -1}}).
+		SetLimit(int64(limit) + 1)
+	cursor, err = database.FileCollection.Find(ctx, filter, opts)
+	if err == nil {
+		err = cursor.All(ctx, &files)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch files from database", http.StatusInternalServerError)
+		log.Printf("Error scanning for policy violations: %v", err)
+		return
+	}
+	if len(files) > limit {
+		files = files[:limit]
+		report.Truncated = true
+	}
+	for _, file := range files {
+		violation := policyViolation(global, file.OriginalFilename, file.FileType)
+		if violation == nil {
+			violation = policyViolation(byWorkspace[file.WorkspaceID], file.OriginalFilename, file.FileType)
+		}
+		// The database match is case-insensitive on the raw type, so it can
+		// be a little broader than the policy itself.
+		if violation != nil {
+			report.Violations = append(report.Violations, FileViolation{File: file, Violation: violation})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
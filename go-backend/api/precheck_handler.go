@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"file-hub-go/audit"
+	"file-hub-go/config"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxPrecheckCandidates bounds the files looked at to find a readable copy
+// of some content.
+const maxPrecheckCandidates = 1000
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// PrecheckRequest describes a file the client is about to upload.
+type PrecheckRequest struct {
+	Hash        string                 `json:"hash"` // Hex SHA-256 of the content
+	Size        int64                  `json:"size"`
+	Filename    string                 `json:"filename"`
+	ContentType string                 `json:"content_type"`
+	WorkspaceID string                 `json:"workspace_id"`
+	FolderID    string                 `json:"folder_id"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+// PrecheckResponse either carries the file created from known content, or
+// tells the client where to upload the bytes.
+type PrecheckResponse struct {
+	Uploaded  bool         `json:"uploaded"`
+	File      *models.File `json:"file,omitempty"`
+	UploadURL string       `json:"upload_url,omitempty"`
+}
+
+// knownBlob returns the stored content with the given hash if the instant
+// upload policy and the deduplication scope let the caller reuse it. Under
+// the default policy that is only content the caller can already read in
+// some file, so the answer reveals nothing about files they cannot see.
+func knownBlob(ctx context.Context, hash string, size int64, owner blobOwner) (storedBlob, bool, error) {
+	blob := storedBlob{Hash: hash, Size: size}
+	policy := config.AppConfig.InstantUpload
+	filter := dedupFilter(hash, owner)
+	if policy == config.InstantUploadOff || filter == nil {
+		return blob, false, nil
+	}
+
+	if policy == config.InstantUploadAccessible {
+		filter["deleted_at"] = nil
+	}
+	opts := options.Find().
+		SetProjection(bson.M{"file": 1, "hash": 1, "owner": 1, "workspace_id": 1, "versions.hash": 1, "versions.file": 1, "versions.uploaded_by": 1}).
+		SetLimit(maxPrecheckCandidates)
+	cursor, err := database.FileCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return blob, false, err
+	}
+	defer cursor.Close(ctx)
+
+	roles := map[string]string{}
+	for cursor.Next(ctx) {
+		var file models.File
+		if err := cursor.Decode(&file); err != nil {
+			return blob, false, err
+		}
+		if policy == config.InstantUploadAccessible {
+			role, ok := roles[file.WorkspaceID]
+			if !ok {
+				if role, err = workspaceRole(ctx, owner.Username, file.WorkspaceID); err != nil {
+					return blob, false, err
+				}
+				roles[file.WorkspaceID] = role
+			}
+			if role == "" {
+				continue
+			}
+		}
+		blob.Path = blobPath(file, hash, owner)
+		break
+	}
+	if err := cursor.Err(); err != nil {
+		return blob, false, err
+	}
+	if blob.Path == "" {
+		return blob, false, nil
+	}
+
+	// The client only claims the hash, so the size must match too.
+	info, err := os.Stat(strings.TrimPrefix(blob.Path, "/"))
+	if err != nil {
+		log.Printf("Error checking stored content %s: %v", hash, err)
+		return blob, false, nil
+	}
+	if info.Size() != size {
+		return blob, false, nil
+	}
+	return blob, true, nil
+}
+
+// PrecheckUpload lets a client skip uploading content the server already
+// has. The client sends the SHA-256 and size of the file along with the
+// fields it would upload. If the content is known, and the caller is allowed
+// to reuse it, the file is created right away and the response is 201 with
+// the new file. Otherwise the response is 200 with the URL to upload to.
+// The answer for content the caller may not reuse is the same as for
+// content that does not exist.
+func PrecheckUpload(w http.ResponseWriter, r *http.Request) {
+	var req PrecheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Hash = strings.ToLower(req.Hash)
+	if !sha256Pattern.MatchString(req.Hash) {
+		http.Error(w, "hash must be a hex-encoded SHA-256", http.StatusBadRequest)
+		return
+	}
+	if req.Size < 0 || req.Size > config.AppConfig.MaxUploadSize {
+		maxSizeMB := config.AppConfig.MaxUploadSize / 1024 / 1024
+		http.Error(w, fmt.Sprintf("The uploaded file is too big. Please choose a file less than %dMB.", maxSizeMB), http.StatusBadRequest)
+		return
+	}
+	if err := validateName("filename", req.Filename); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if !authorizeWorkspace(ctx, w, r, req.WorkspaceID, true) {
+		return
+	}
+	if exists, err := folderInWorkspace(ctx, req.FolderID, req.WorkspaceID); err != nil {
+		http.Error(w, "Failed to fetch folder from database", http.StatusInternalServerError)
+		return
+	} else if !exists {
+		http.Error(w, "Folder not found", http.StatusBadRequest)
+		return
+	}
+	schema, err := loadMetadataSchema(ctx, req.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch metadata schema from database", http.StatusInternalServerError)
+		return
+	}
+	if req.Metadata == nil {
+		req.Metadata = map[string]interface{}{}
+	}
+	metadata, err := validateMetadata(schema, req.Metadata)
+	if err == nil {
+		err = checkRequiredMetadata(schema, metadata)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	username := currentUser(r)
+	blob, known, err := knownBlob(ctx, req.Hash, req.Size, blobOwner{username, req.WorkspaceID})
+	if err != nil {
+		http.Error(w, "Failed to fetch files from database", http.StatusInternalServerError)
+		log.Printf("Error checking for content %s: %v", req.Hash, err)
+		return
+	}
+	if !known {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PrecheckResponse{UploadURL: "/api/files/"})
+		return
+	}
+
+	// Detect the type and extract the text again so the new file is
+	// searchable under its own name, and scan it like an upload. Content
+	// that cannot be read is treated as unknown, as in knownBlob, so it is
+	// never saved unscanned.
+	src, err := os.Open(strings.TrimPrefix(blob.Path, "/"))
+	if err != nil {
+		log.Printf("Error opening stored content %s: %v", req.Hash, err)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PrecheckResponse{UploadURL: "/api/files/"})
+		return
+	}
+	blob.FileType, blob.DeclaredType, blob.TypeMismatch = detectType(src, req.Filename, req.ContentType)
+	blob.Content = extractContent(src, blob.Size, req.Filename, blob.FileType)
+	blob.Scan, err = scanUpload(ctx, src, blob.Size)
+	src.Close()
+	if err != nil {
+		writeStoreError(w, req.Filename, err)
+		return
+	}
+	if !enforceUploadPolicy(ctx, w, req.WorkspaceID, req.Filename, blob.FileType) {
+		return
+	}
+	newFile := newUploadedFile(blob, req.Filename, username)
+	newFile.WorkspaceID = req.WorkspaceID
+	newFile.FolderID = req.FolderID
+	newFile.Metadata = metadata
+	if _, err := database.FileCollection.InsertOne(ctx, newFile); err != nil {
+		http.Error(w, "Could not save file metadata", http.StatusInternalServerError)
+		return
+	}
+	queueScan()
+	queueThumbnails(blob)
+	audit.Log(username, "file.upload", newFile.ID, map[string]string{
+		"filename": newFile.OriginalFilename,
+		"hash":     newFile.Hash,
+		"instant":  "true",
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(PrecheckResponse{Uploaded: true, File: &newFile})
+}
@@ -0,0 +1,316 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The search parameter of GetFiles is a small query language:
+//
+//	report                  name contains "report"
+//	"annual report"         name contains the quoted phrase
+//	name:*.pdf              name matches the glob (* matches anything)
+//	type:image/*            file type matches the glob
+//	size>5MB size<=1GB      size comparisons with B, KB, MB or GB
+//	uploaded<2025-01-01     upload date comparisons (:, <, <=, >, >=)
+//	tag:finance owner:bob   exact tag or owner
+//	content:revenue         full-text search over the extracted document text
+//	-tag:draft              any term can be negated with a leading '-'
+//
+// All terms must match. Content terms together form a single MongoDB $text
+// search, which ranks the results; at least one of them must not be negated.
+// Values are matched literally; only '*' in name and type values has a
+// special meaning, so user input never reaches MongoDB as a regular
+// expression.
+
+const (
+	maxQueryLength = 1000
+	maxQueryTerms  = 32
+)
+
+// queryError is a search query that could not be parsed. Pos is the byte
+// offset of the offending term.
+type queryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *queryError) Error() string {
+	return fmt.Sprintf("invalid search query at position %d: %s", e.Pos, e.Msg)
+}
+
+// queryTerm is one whitespace-separated term of a query.
+type queryTerm struct {
+	Pos    int
+	Negate bool
+	Field  string // Empty for a bare word
+	Op     string // ":", "<", "<=", ">" or ">="
+	Value  string
+}
+
+var sizeUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"kb": 1 << 10,
+	"mb": 1 << 20,
+	"gb": 1 << 30,
+	"tb": 1 << 40,
+}
+
+var sizePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([a-zA-Z]*)$`)
+
+// searchQuery is a parsed search query.
+type searchQuery struct {
+	Filter bson.D
+	// ContentWords are the words of the content terms that must match. They
+	// are empty unless the query contains a content search.
+	ContentWords []string
+}
+
+// parseSearchQuery parses a search query into a MongoDB filter. An empty
+// query yields an empty filter.
+func parseSearchQuery(q string) (searchQuery, error) {
+	var result searchQuery
+	if len(q) > maxQueryLength {
+		return result, &queryError{Pos: maxQueryLength, Msg: fmt.Sprintf("query must be at most %d characters", maxQueryLength)}
+	}
+	terms, err := tokenizeQuery(q)
+	if err != nil {
+		return result, err
+	}
+	if len(terms) > maxQueryTerms {
+		return result, &queryError{Pos: terms[maxQueryTerms].Pos, Msg: fmt.Sprintf("query must have at most %d terms", maxQueryTerms)}
+	}
+
+	conditions := bson.A{}
+	var textSearch []string
+	for _, term := range terms {
+		if term.Field == "content" {
+			phrase, words, err := contentPhrase(term)
+			if err != nil {
+				return result, err
+			}
+			textSearch = append(textSearch, phrase)
+			if !term.Negate {
+				result.ContentWords = append(result.ContentWords, words...)
+			}
+			continue
+		}
+
+		cond, err := termCondition(term)
+		if err != nil {
+			return result, err
+		}
+		if term.Negate {
+			cond = bson.D{{Key: "$nor", Value: bson.A{cond}}}
+		}
+		conditions = append(conditions, cond)
+	}
+
+	result.Filter = bson.D{}
+	if len(textSearch) > 0 {
+		if len(result.ContentWords) == 0 {
+			return result, &queryError{Pos: 0, Msg: "a content search needs at least one term that is not negated"}
+		}
+		// $text may only appear once, at the top level of the filter.
+		result.Filter = append(result.Filter, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: strings.Join(textSearch, " ")}}})
+	}
+	if len(conditions) > 0 {
+		result.Filter = append(result.Filter, bson.E{Key: "$and", Value: conditions})
+	}
+	return result, nil
+}
+
+// contentPhrase converts a content term into $text search syntax and returns
+// its words. Values with spaces become phrases, and negation uses the $text
+// '-' prefix.
+func contentPhrase(t queryTerm) (string, []string, error) {
+	if t.Op != ":" {
+		return "", nil, &queryError{Pos: t.Pos, Msg: "content only supports ':'"}
+	}
+	// Quotes and dashes have a meaning in $text syntax, so drop them from
+	// the value itself.
+	words := strings.Fields(strings.NewReplacer(`"`, " ", "-", " ").Replace(t.Value))
+	if len(words) == 0 {
+		return "", nil, &queryError{Pos: t.Pos, Msg: "missing value"}
+	}
+	phrase := strings.Join(words, " ")
+	if len(words) > 1 {
+		phrase = `"` + phrase + `"`
+	}
+	if t.Negate {
+		phrase = "-" + phrase
+	}
+	return phrase, words, nil
+}
+
+// tokenizeQuery splits a query into terms. Double quotes group a value that
+// contains spaces, e.g. name:"annual report".
+func tokenizeQuery(q string) ([]queryTerm, error) {
+	var terms []queryTerm
+	i := 0
+	for i < len(q) {
+		if q[i] == ' ' || q[i] == '\t' {
+			i++
+			continue
+		}
+
+		term := queryTerm{Pos: i}
+		if q[i] == '-' && i+1 < len(q) && q[i+1] != ' ' {
+			term.Negate = true
+			i++
+		}
+
+		// Read an optional field name followed by an operator.
+		start := i
+		for i < len(q) {
+			r, size := utf8.DecodeRuneInString(q[i:])
+			if !unicode.IsLetter(r) && r != '_' {
+				break
+			}
+			i += size
+		}
+		if i < len(q) && i > start && strings.ContainsRune(":<>", rune(q[i])) {
+			term.Field = strings.ToLower(q[start:i])
+			term.Op = string(q[i])
+			i++
+			if term.Op != ":" && i < len(q) && q[i] == '=' {
+				term.Op += "="
+				i++
+			}
+		} else {
+			i = start
+		}
+
+		// Read the value, either quoted or up to the next space.
+		if i < len(q) && q[i] == '"' {
+			end := strings.IndexByte(q[i+1:], '"')
+			if end < 0 {
+				return nil, &queryError{Pos: i, Msg: "unterminated quote"}
+			}
+			term.Value = q[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			start := i
+			for i < len(q) && q[i] != ' ' && q[i] != '\t' {
+				i++
+			}
+			term.Value = q[start:i]
+		}
+		if term.Value == "" {
+			return nil, &queryError{Pos: term.Pos, Msg: "missing value"}
+		}
+		terms = append(terms, term)
+	}
+	return terms, nil
+}
+
+// termCondition converts a single term into a MongoDB condition.
+func termCondition(t queryTerm) (bson.D, error) {
+	fail := func(format string, args ...interface{}) (bson.D, error) {
+		return nil, &queryError{Pos: t.Pos, Msg: fmt.Sprintf(format, args...)}
+	}
+	requireEquals := func() error {
+		if t.Op != ":" {
+			return &queryError{Pos: t.Pos, Msg: fmt.Sprintf("%s only supports ':'", t.Field)}
+		}
+		return nil
+	}
+
+	switch t.Field {
+	case "":
+		return bson.D{{Key: "original_filename", Value: literalPattern(t.Value, false)}}, nil
+	case "name":
+		if err := requireEquals(); err != nil {
+			return nil, err
+		}
+		return bson.D{{Key: "original_filename", Value: literalPattern(t.Value, strings.Contains(t.Value, "*"))}}, nil
+	case "type":
+		if err := requireEquals(); err != nil {
+			return nil, err
+		}
+		return bson.D{{Key: "file_type", Value: literalPattern(t.Value, true)}}, nil
+	case "tag":
+		if err := requireEquals(); err != nil {
+			return nil, err
+		}
+		return bson.D{{Key: "tags", Value: strings.ToLower(t.Value)}}, nil
+	case "owner":
+		if err := requireEquals(); err != nil {
+			return nil, err
+		}
+		return bson.D{{Key: "owner", Value: t.Value}}, nil
+	case "size":
+		m := sizePattern.FindStringSubmatch(t.Value)
+		if m == nil {
+			return fail("size must be a number with an optional unit (B, KB, MB, GB, TB)")
+		}
+		unit, ok := sizeUnits[strings.ToLower(m[2])]
+		if !ok {
+			return fail("unknown size unit %q", m[2])
+		}
+		n, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			return fail("invalid size %q", m[1])
+		}
+		// float64(math.MaxInt64) rounds up to 2^63, which is out of range.
+		if n*float64(unit) >= float64(math.MaxInt64) {
+			return fail("size %q is too large", t.Value)
+		}
+		size := int64(n * float64(unit))
+		op := map[string]string{":": "$eq", "<": "$lt", "<=": "$lte", ">": "$gt", ">=": "$gte"}[t.Op]
+		return bson.D{{Key: "size", Value: bson.D{{Key: op, Value: size}}}}, nil
+	case "uploaded":
+		day, err := time.Parse("2006-01-02", t.Value)
+		if err != nil {
+			return fail("uploaded must be a date in YYYY-MM-DD format")
+		}
+		next := day.AddDate(0, 0, 1)
+		// A date stands for the whole day, so "after" means from the next day on.
+		var cond bson.D
+		switch t.Op {
+		case ":":
+			cond = bson.D{{Key: "$gte", Value: day}, {Key: "$lt", Value: next}}
+		case "<":
+			cond = bson.D{{Key: "$lt", Value: day}}
+		case "<=":
+			cond = bson.D{{Key: "$lt", Value: next}}
+		case ">":
+			cond = bson.D{{Key: "$gte", Value: next}}
+		case ">=":
+			cond = bson.D{{Key: "$gte", Value: day}}
+		}
+		return bson.D{{Key: "uploaded_at", Value: cond}}, nil
+	default:
+		return fail("unknown field %q", t.Field)
+	}
+}
+
+// literalPattern builds a case-insensitive regular expression that matches
+// value literally. With glob set, '*' matches any run of characters and the
+// whole string must match; otherwise value may appear anywhere.
+func literalPattern(value string, glob bool) bson.D {
+	pattern := regexp.QuoteMeta(value)
+	if glob {
+		pattern = globPattern(value)
+	}
+	return bson.D{{Key: "$regex", Value: pattern}, {Key: "$options", Value: "i"}}
+}
+
+// globPattern turns a pattern where * matches any run of characters into an
+// anchored regular expression.
+func globPattern(value string) string {
+	parts := strings.Split(value, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return "^" + strings.Join(parts, ".*") + "$"
+}
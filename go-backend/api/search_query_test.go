@@ -0,0 +1,96 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func regex(pattern string) bson.D {
+	return bson.D{{Key: "$regex", Value: pattern}, {Key: "$options", Value: "i"}}
+}
+
+func and(conditions ...bson.D) bson.D {
+	all := bson.A{}
+	for _, cond := range conditions {
+		all = append(all, cond)
+	}
+	return bson.D{{Key: "$and", Value: all}}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		q     string
+		want  bson.D
+		words []string
+	}{
+		{q: "", want: bson.D{}},
+		{q: "report", want: and(bson.D{{Key: "original_filename", Value: regex("report")}})},
+		{q: `"annual report"`, want: and(bson.D{{Key: "original_filename", Value: regex(`annual report`)}})},
+		{q: "a.b", want: and(bson.D{{Key: "original_filename", Value: regex(`a\.b`)}})},
+		{q: "name:*.pdf", want: and(bson.D{{Key: "original_filename", Value: regex(`^.*\.pdf$`)}})},
+		{q: `name:"q1 (draft)"`, want: and(bson.D{{Key: "original_filename", Value: regex(`q1 \(draft\)`)}})},
+		{q: "type:image/*", want: and(bson.D{{Key: "file_type", Value: regex(`^image/.*$`)}})},
+		{q: "type:text/x-c++", want: and(bson.D{{Key: "file_type", Value: regex(`^text/x-c\+\+$`)}})},
+		{q: "size>5MB", want: and(bson.D{{Key: "size", Value: bson.D{{Key: "$gt", Value: int64(5 << 20)}}}})},
+		{q: "size<=1.5kb", want: and(bson.D{{Key: "size", Value: bson.D{{Key: "$lte", Value: int64(1536)}}}})},
+		{q: "size:100", want: and(bson.D{{Key: "size", Value: bson.D{{Key: "$eq", Value: int64(100)}}}})},
+		{q: "uploaded<2025-01-01", want: and(bson.D{{Key: "uploaded_at", Value: bson.D{{Key: "$lt", Value: day}}}})},
+		{q: "uploaded>2025-01-01", want: and(bson.D{{Key: "uploaded_at", Value: bson.D{{Key: "$gte", Value: day.AddDate(0, 0, 1)}}}})},
+		{q: "uploaded:2025-01-01", want: and(bson.D{{Key: "uploaded_at", Value: bson.D{{Key: "$gte", Value: day}, {Key: "$lt", Value: day.AddDate(0, 0, 1)}}}})},
+		{q: "Tag:Finance owner:bob", want: and(bson.D{{Key: "tags", Value: "finance"}}, bson.D{{Key: "owner", Value: "bob"}})},
+		{q: "-tag:draft", want: and(bson.D{{Key: "$nor", Value: bson.A{bson.D{{Key: "tags", Value: "draft"}}}}})},
+		{q: "été", want: and(bson.D{{Key: "original_filename", Value: regex("été")}})},
+		{
+			q:     `content:revenue -content:"net loss" tag:q1`,
+			want:  bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: `revenue -"net loss"`}}}, {Key: "$and", Value: bson.A{bson.D{{Key: "tags", Value: "q1"}}}}},
+			words: []string{"revenue"},
+		},
+	}
+	for _, test := range tests {
+		got, err := parseSearchQuery(test.q)
+		if err != nil {
+			t.Errorf("parseSearchQuery(%q): %v", test.q, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Filter, test.want) {
+			t.Errorf("parseSearchQuery(%q).Filter = %v, want %v", test.q, got.Filter, test.want)
+		}
+		if !reflect.DeepEqual(got.ContentWords, test.words) {
+			t.Errorf("parseSearchQuery(%q).ContentWords = %v, want %v", test.q, got.ContentWords, test.words)
+		}
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{`name:"annual report`, `invalid search query at position 5: unterminated quote`},
+		{`report tag:`, `invalid search query at position 7: missing value`},
+		{`content:"-"`, `invalid search query at position 0: missing value`},
+		{`name<report`, `invalid search query at position 0: name only supports ':'`},
+		{`content>revenue`, `invalid search query at position 0: content only supports ':'`},
+		{`-content:revenue`, `invalid search query at position 0: a content search needs at least one term that is not negated`},
+		{`size>big`, `invalid search query at position 0: size must be a number with an optional unit (B, KB, MB, GB, TB)`},
+		{`size>5XB`, `invalid search query at position 0: unknown size unit "XB"`},
+		{`size>9999999TB`, `invalid search query at position 0: size "9999999TB" is too large`},
+		{`size>9223372036854775807`, `invalid search query at position 0: size "9223372036854775807" is too large`},
+		{`uploaded>2025-13-01`, `invalid search query at position 0: uploaded must be a date in YYYY-MM-DD format`},
+		{`color:red`, `invalid search query at position 0: unknown field "color"`},
+		{`größe:1`, `invalid search query at position 0: unknown field "größe"`},
+		{strings.Repeat("a", maxQueryLength+1), `invalid search query at position 1000: query must be at most 1000 characters`},
+		{strings.Repeat("a ", maxQueryTerms+1), `invalid search query at position 64: query must have at most 32 terms`},
+	}
+	for _, test := range tests {
+		_, err := parseSearchQuery(test.q)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("parseSearchQuery(%q) error = %v, want %q", test.q, err, test.want)
+		}
+	}
+}
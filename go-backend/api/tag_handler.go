@@ -0,0 +1,331 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"file-hub-go/audit"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxTagLength   = 64
+	maxTagsPerFile = 100
+	maxBulkFiles   = 1000
+)
+
+// normalizeTags trims and lowercases tags and removes duplicates, so that
+// "Finance" and "finance " are the same tag.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return nil, fmt.Errorf("tags must not be empty")
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLength)
+		}
+		for _, c := range tag {
+			if c < 0x20 || c == 0x7f || c == ',' {
+				return nil, fmt.Errorf("tags must not contain commas or control characters")
+			}
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTagsPerFile {
+		return nil, fmt.Errorf("a file can have at most %d tags", maxTagsPerFile)
+	}
+	return normalized, nil
+}
+
+// splitTags parses a comma-separated tag list from a query parameter.
+func splitTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// tooManyTags reports whether adding tags would take a file over
+// maxTagsPerFile. Tags the file already has are not counted again.
+func tooManyTags(current, add []string) bool {
+	count := len(current)
+	for _, tag := range add {
+		if !slices.Contains(current, tag) {
+			count++
+		}
+	}
+	return count > maxTagsPerFile
+}
+
+// checkTagChange rejects a change that both adds and removes a tag.
+func checkTagChange(add, remove []string) error {
+	for _, tag := range add {
+		if slices.Contains(remove, tag) {
+			return fmt.Errorf("tag %q cannot be both added and removed", tag)
+		}
+	}
+	return nil
+}
+
+// tagUpdates builds the update documents that add and remove tags. MongoDB
+// rejects an update that applies two operators to the same field, so adding
+// and removing are separate updates. checkTagChange makes sure their order
+// does not matter.
+func tagUpdates(add, remove []string) []bson.D {
+	now := time.Now()
+	var updates []bson.D
+	if len(add) > 0 {
+		updates = append(updates, bson.D{
+			{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}}},
+			{Key: "$addToSet", Value: bson.D{{Key: "tags", Value: bson.D{{Key: "$each", Value: add}}}}},
+		})
+	}
+	if len(remove) > 0 {
+		updates = append(updates, bson.D{
+			{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}}},
+			{Key: "$pullAll", Value: bson.D{{Key: "tags", Value: remove}}},
+		})
+	}
+	return updates
+}
+
+// updateTags adds and removes tags on the files matching filter.
+func updateTags(ctx context.Context, filter bson.M, add, remove []string) error {
+	for _, update := range tagUpdates(add, remove) {
+		if _, err := database.FileCollection.UpdateMany(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddFileTags adds tags to a single file and returns the updated file.
+func AddFileTags(w http.ResponseWriter, r *http.Request) {
+	fileID := chi.URLParam(r, "id")
+
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(tags) == 0 {
+		http.Error(w, "No tags provided", http.StatusBadRequest)
+		return
+	}
+
+	updateFileTags(w, r, fileID, tags, nil)
+}
+
+// RemoveFileTag removes a single tag from a file and returns the updated file.
+func RemoveFileTag(w http.ResponseWriter, r *http.Request) {
+	fileID := chi.URLParam(r, "id")
+	tag := strings.ToLower(strings.TrimSpace(chi.URLParam(r, "tag")))
+
+	updateFileTags(w, r, fileID, nil, []string{tag})
+}
+
+func updateFileTags(w http.ResponseWriter, r *http.Request, fileID string, add, remove []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	file, ok := authorizeFile(ctx, w, r, fileID, true)
+	if !ok {
+		return
+	}
+	if tooManyTags(file.Tags, add) {
+		http.Error(w, fmt.Sprintf("a file can have at most %d tags", maxTagsPerFile), http.StatusBadRequest)
+		return
+	}
+
+	var updated models.File
+	var err error
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	for _, update := range tagUpdates(add, remove) {
+		err = database.FileCollection.FindOneAndUpdate(ctx, bson.M{"_id": fileID, "deleted_at": nil}, update, opts).Decode(&updated)
+		if err != nil {
+			break
+		}
+	}
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update tags", http.StatusInternalServerError)
+		log.Printf("Error updating tags of file %s: %v", fileID, err)
+		return
+	}
+	audit.Log(currentUser(r), "file.tag", fileID, map[string]string{
+		"add":    strings.Join(add, ","),
+		"remove": strings.Join(remove, ","),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// BulkTagResult reports which files of a bulk tag request were updated.
+// Files that could not be updated are listed with the reason.
+type BulkTagResult struct {
+	Updated []string          `json:"updated"`
+	Failed  map[string]string `json:"failed"`
+}
+
+// BulkTagFiles adds and removes tags on many files at once.
+func BulkTagFiles(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FileIDs []string `json:"file_ids"`
+		Add     []string `json:"add"`
+		Remove  []string `json:"remove"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.FileIDs) == 0 || len(req.FileIDs) > maxBulkFiles {
+		http.Error(w, fmt.Sprintf("file_ids must contain between 1 and %d IDs", maxBulkFiles), http.StatusBadRequest)
+		return
+	}
+	add, err := normalizeTags(req.Add)
+	if err == nil {
+		req.Remove, err = normalizeTags(req.Remove)
+	}
+	if err == nil {
+		err = checkTagChange(add, req.Remove)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(add) == 0 && len(req.Remove) == 0 {
+		http.Error(w, "No tags provided", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var files []models.File
+	cursor, err := database.FileCollection.Find(ctx, bson.M{"_id": bson.M{"$in": req.FileIDs}, "deleted_at": nil})
+	if err == nil {
+		err = cursor.All(ctx, &files)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch files from database", http.StatusInternalServerError)
+		log.Printf("Error fetching files for bulk tagging: %v", err)
+		return
+	}
+
+	// Check write access once per workspace rather than once per file.
+	username := currentUser(r)
+	result := BulkTagResult{Updated: []string{}, Failed: map[string]string{}}
+	roles := map[string]string{}
+	found := map[string]bool{}
+	var allowed []string
+	for _, file := range files {
+		found[file.ID] = true
+		role, ok := roles[file.WorkspaceID]
+		if !ok {
+			if role, err = workspaceRole(ctx, username, file.WorkspaceID); err != nil {
+				http.Error(w, "Failed to fetch workspace from database", http.StatusInternalServerError)
+				return
+			}
+			roles[file.WorkspaceID] = role
+		}
+		switch {
+		case role == "":
+			result.Failed[file.ID] = "File not found"
+		case !canWrite(role):
+			result.Failed[file.ID] = "You do not have write access to this file"
+		case tooManyTags(file.Tags, add):
+			result.Failed[file.ID] = fmt.Sprintf("a file can have at most %d tags", maxTagsPerFile)
+		default:
+			allowed = append(allowed, file.ID)
+		}
+	}
+	for _, id := range req.FileIDs {
+		if !found[id] {
+			result.Failed[id] = "File not found"
+		}
+	}
+
+	if len(allowed) > 0 {
+		if err := updateTags(ctx, bson.M{"_id": bson.M{"$in": allowed}}, add, req.Remove); err != nil {
+			http.Error(w, "Failed to update tags", http.StatusInternalServerError)
+			log.Printf("Error bulk updating tags: %v", err)
+			return
+		}
+		result.Updated = allowed
+		for _, id := range allowed {
+			audit.Log(username, "file.tag", id, map[string]string{
+				"add":    strings.Join(add, ","),
+				"remove": strings.Join(req.Remove, ","),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// TagCount is a tag together with the number of files carrying it.
+type TagCount struct {
+	Tag   string `bson:"_id" json:"tag"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// GetTags lists the tags used on the caller's files with their counts.
+func GetTags(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "owner", Value: currentUser(r)}, {Key: "deleted_at", Value: nil}}}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$tags"}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	cursor, err := database.FileCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		http.Error(w, "Failed to fetch tags from database", http.StatusInternalServerError)
+		log.Printf("Error aggregating tags: %v", err)
+		return
+	}
+	tags := []TagCount{}
+	if err := cursor.All(ctx, &tags); err != nil {
+		http.Error(w, "Failed to decode tags", http.StatusInternalServerError)
+		log.Printf("Error decoding tags: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
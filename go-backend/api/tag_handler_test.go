@@ -0,0 +1,80 @@
+package api
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// applyTagUpdate applies the $addToSet and $pullAll of an update to tags
+// the way MongoDB does, and fails on updates MongoDB would reject because
+// two operators change the same field.
+func applyTagUpdate(t *testing.T, tags []string, update bson.D) []string {
+	t.Helper()
+	changed := map[string]string{}
+	for _, op := range update {
+		for _, field := range op.Value.(bson.D) {
+			if other, ok := changed[field.Key]; ok {
+				t.Fatalf("update %v changes %s with both %s and %s", update, field.Key, other, op.Key)
+			}
+			changed[field.Key] = op.Key
+			switch op.Key {
+			case "$addToSet":
+				for _, tag := range field.Value.(bson.D)[0].Value.([]string) {
+					if !slices.Contains(tags, tag) {
+						tags = append(tags, tag)
+					}
+				}
+			case "$pullAll":
+				remove := field.Value.([]string)
+				tags = slices.DeleteFunc(tags, func(tag string) bool { return slices.Contains(remove, tag) })
+			}
+		}
+	}
+	return tags
+}
+
+func TestTagUpdates(t *testing.T) {
+	tests := []struct {
+		add, remove []string
+		want        []string
+	}{
+		{add: []string{"b", "c"}, want: []string{"a", "b", "c"}},
+		{remove: []string{"a", "x"}, want: []string{"b"}},
+		{add: []string{"c"}, remove: []string{"a"}, want: []string{"b", "c"}},
+	}
+	for _, test := range tests {
+		tags := []string{"a", "b"}
+		for _, update := range tagUpdates(test.add, test.remove) {
+			tags = applyTagUpdate(t, tags, update)
+		}
+		if !slices.Equal(tags, test.want) {
+			t.Errorf("add %v, remove %v: tags = %v, want %v", test.add, test.remove, tags, test.want)
+		}
+	}
+}
+
+func TestCheckTagChange(t *testing.T) {
+	if err := checkTagChange([]string{"a", "b"}, []string{"c"}); err != nil {
+		t.Errorf("disjoint lists: %v", err)
+	}
+	err := checkTagChange([]string{"a", "b"}, []string{"b"})
+	if err == nil || !strings.Contains(err.Error(), `"b"`) {
+		t.Errorf("overlapping lists: err = %v, want one naming \"b\"", err)
+	}
+}
+
+func TestTooManyTags(t *testing.T) {
+	current := make([]string, maxTagsPerFile)
+	for i := range current {
+		current[i] = strings.Repeat("t", i+1)
+	}
+	if tooManyTags(current, current[:10]) {
+		t.Error("re-adding existing tags counts against the limit")
+	}
+	if !tooManyTags(current, []string{"new"}) {
+		t.Error("a tag over the limit is allowed")
+	}
+}
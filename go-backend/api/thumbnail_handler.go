@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"file-hub-go/config"
+	"file-hub-go/database"
+	"file-hub-go/imaging"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// thumbnailSizes are the longest side of each thumbnail size in pixels.
+var thumbnailSizes = map[string]int{
+	"small":  128,
+	"medium": 256,
+	"large":  512,
+}
+
+const defaultThumbnailSize = "medium"
+
+// thumbnailJob is content to generate thumbnails for.
+type thumbnailJob struct {
+	Hash     string
+	Path     string // URL path of the physical file
+	FileType string
+}
+
+var (
+	thumbnailQueue = make(chan thumbnailJob, 100)
+	// decodeMu lets one image be decoded at a time, which bounds the
+	// memory used for thumbnails and transformations.
+	decodeMu sync.Mutex
+)
+
+// thumbnailDir is where thumbnails are stored, next to the uploads.
+func thumbnailDir() string {
+	return filepath.Join(config.AppConfig.UploadDir, "thumbnails")
+}
+
+// thumbnailFormat is the format of the thumbnails of an image: PNG for PNG
+// and GIF images so transparency is kept, JPEG for photos.
+func thumbnailFormat(fileType string) string {
+	if fileType == "image/jpeg" {
+		return "jpeg"
+	}
+	return "png"
+}
+
+// thumbnailPath returns where the thumbnail of the given size of content
+// is stored. Thumbnails are keyed by the content hash, so files with the
+// same content share them.
+func thumbnailPath(hash, size, fileType string) string {
+	ext := ".png"
+	if thumbnailFormat(fileType) == "jpeg" {
+		ext = ".jpg"
+	}
+	return filepath.Join(thumbnailDir(), hash+"-"+size+ext)
+}
+
+// generateThumbnails writes the thumbnails of every size for content that
+// does not have them yet.
+func generateThumbnails(job thumbnailJob) error {
+	decodeMu.Lock()
+	defer decodeMu.Unlock()
+
+	missing := false
+	for size := range thumbnailSizes {
+		if _, err := os.Stat(thumbnailPath(job.Hash, size, job.FileType)); err != nil {
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	content, err := os.Open(strings.TrimPrefix(job.Path, "/"))
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	src, err := imaging.Decode(content)
+	if err != nil {
+		return err
+	}
+	for size, pixels := range thumbnailSizes {
+		if err := writeImageFile(thumbnailPath(job.Hash, size, job.FileType), src.Thumbnail(pixels), thumbnailFormat(job.FileType)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeImageFile encodes an image to a file. It is written under a
+// temporary name first so readers never see a partial image.
+func writeImageFile(path string, img image.Image, format string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	err = imaging.Encode(tmp, img, format, 85)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// queueThumbnails has thumbnails generated in the background for newly
+// stored content that is an image.
+func queueThumbnails(blob storedBlob) {
+	if !imaging.Supported(blob.FileType) {
+		return
+	}
+	select {
+	case thumbnailQueue <- thumbnailJob{Hash: blob.Hash, Path: blob.Path, FileType: blob.FileType}:
+	default:
+		// The thumbnails are generated on first request instead.
+		log.Printf("Thumbnail queue full, skipping %s", blob.Hash)
+	}
+}
+
+// releaseDerivedImages deletes the thumbnails and transformed images of
+// content that no file or version has any more.
+func releaseDerivedImages(ctx context.Context, hash string) {
+	count, err := database.FileCollection.CountDocuments(ctx, bson.M{"$or": bson.A{bson.M{"hash": hash}, bson.M{"versions.hash": hash}}})
+	if err != nil || count > 0 {
+		return
+	}
+	paths, _ := filepath.Glob(filepath.Join(thumbnailDir(), hash+"-*"))
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			log.Printf("Failed to delete thumbnail %s: %v", path, err)
+		}
+	}
+	transformCache.removeHash(hash)
+}
+
+// StartThumbnailer generates the thumbnails of uploaded images in the
+// background.
+func StartThumbnailer() {
+	if err := os.MkdirAll(thumbnailDir(), 0755); err != nil {
+		log.Fatalf("Could not create thumbnail directory: %v", err)
+	}
+	go func() {
+		for job := range thumbnailQueue {
+			if err := generateThumbnails(job); err != nil {
+				log.Printf("Could not generate thumbnails for %s: %v", job.Hash, err)
+			}
+		}
+	}()
+}
+
+// GetThumbnail serves a preview of a JPEG, PNG or GIF file, fitted in a
+// square of the requested `size` (small, medium or large). Thumbnails that
+// are not ready yet are generated on the spot.
+func GetThumbnail(w http.ResponseWriter, r *http.Request) {
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = defaultThumbnailSize
+	}
+	if _, ok := thumbnailSizes[size]; !ok {
+		http.Error(w, "size must be small, medium or large", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	file, ok := authorizeFile(ctx, w, r, chi.URLParam(r, "id"), false)
+	if !ok || !authorizeDownload(w, file.Scan) {
+		return
+	}
+	if !imaging.Supported(file.FileType) || file.Hash == "" {
+		http.Error(w, "Thumbnails are only available for JPEG, PNG and GIF images", http.StatusNotFound)
+		return
+	}
+
+	path := thumbnailPath(file.Hash, size, file.FileType)
+	content, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = generateThumbnails(thumbnailJob{Hash: file.Hash, Path: file.File, FileType: file.FileType})
+		if errors.Is(err, imaging.ErrUnsupported) || errors.Is(err, imaging.ErrTooLarge) {
+			http.Error(w, "No thumbnail can be made of this image", http.StatusNotFound)
+			return
+		}
+		if err == nil {
+			content, err = os.Open(path)
+		}
+	}
+	if err != nil {
+		http.Error(w, "Could not load thumbnail", http.StatusInternalServerError)
+		log.Printf("Error loading thumbnail of %s: %v", file.ID, err)
+		return
+	}
+	defer content.Close()
+
+	// The thumbnail only changes with the content, so its hash makes a
+	// strong validator.
+	w.Header().Set("Content-Type", "image/"+thumbnailFormat(file.FileType))
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%s"`, file.Hash, size))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, "", file.UpdatedAt, content)
+}
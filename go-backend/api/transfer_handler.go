@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"file-hub-go/audit"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Policies for a copy or move whose target name is already taken.
+const (
+	conflictFail      = "fail"
+	conflictRename    = "rename"
+	conflictOverwrite = "overwrite"
+)
+
+// maxRenameAttempts bounds the search for a free "name (n).ext".
+const maxRenameAttempts = 1000
+
+var errNameConflict = errors.New("a file with this name already exists in the destination")
+
+// TransferRequest is the body of the copy and move endpoints. An empty
+// WorkspaceID or FolderID means the default workspace or the root folder.
+type TransferRequest struct {
+	WorkspaceID string `json:"workspace_id"`
+	FolderID    string `json:"folder_id"`
+	Name        string `json:"name"`        // Optional new name, defaults to the current one
+	OnConflict  string `json:"on_conflict"` // fail (default), rename or overwrite
+}
+
+// resolveNameConflict decides the final name of a file placed in a folder
+// according to policy. For overwrite it returns the entries that must be
+// replaced. excludeID is the file being moved, which never conflicts with itself.
+func resolveNameConflict(ctx context.Context, workspaceID, folderID, name, excludeID, policy string) (string, []models.File, error) {
+	taken := func(candidate string) ([]models.File, error) {
+		filter := bson.M{
+			"workspace_id":      emptyOr(workspaceID),
+			"folder_id":         emptyOr(folderID),
+			"original_filename": candidate,
+			"_id":               bson.M{"$ne": excludeID},
+			"deleted_at":        nil,
+		}
+		cursor, err := database.FileCollection.Find(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		var existing []models.File
+		err = cursor.All(ctx, &existing)
+		return existing, err
+	}
+
+	existing, err := taken(name)
+	if err != nil || len(existing) == 0 {
+		return name, nil, err
+	}
+
+	switch policy {
+	case conflictOverwrite:
+		return name, existing, nil
+	case conflictRename:
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		for n := 1; n <= maxRenameAttempts; n++ {
+			candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+			existing, err := taken(candidate)
+			if err != nil {
+				return "", nil, err
+			}
+			if len(existing) == 0 {
+				return candidate, nil, nil
+			}
+		}
+		return "", nil, errNameConflict
+	default:
+		return "", nil, errNameConflict
+	}
+}
+
+// CopyFile creates a new metadata entry for an existing file in another
+// folder or workspace. The copy shares the physical file with the original.
+func CopyFile(w http.ResponseWriter, r *http.Request) {
+	transferFile(w, r, false)
+}
+
+// MoveFile moves a file to another folder or workspace, optionally renaming it.
+func MoveFile(w http.ResponseWriter, r *http.Request) {
+	transferFile(w, r, true)
+}
+
+// validateOnConflict checks the conflict policy of a transfer request and
+// fills in the default.
+func validateOnConflict(req *TransferRequest) error {
+	if req.OnConflict == "" {
+		req.OnConflict = conflictFail
+	}
+	if req.OnConflict != conflictFail && req.OnConflict != conflictRename && req.OnConflict != conflictOverwrite {
+		return errors.New("on_conflict must be fail, rename or overwrite")
+	}
+	return nil
+}
+
+func transferFile(w http.ResponseWriter, r *http.Request, move bool) {
+	fileID := chi.URLParam(r, "id")
+
+	var req TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateOnConflict(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Moving takes the file away from its workspace, so it needs write
+	// access there; copying only needs to read it.
+	source, ok := authorizeFile(ctx, w, r, fileID, move)
+	if !ok {
+		return
+	}
+	if !authorizeWorkspace(ctx, w, r, req.WorkspaceID, true) {
+		return
+	}
+	if exists, err := folderInWorkspace(ctx, req.FolderID, req.WorkspaceID); err != nil {
+		http.Error(w, "Failed to fetch folder from database", http.StatusInternalServerError)
+		return
+	} else if !exists {
+		http.Error(w, "Folder not found", http.StatusBadRequest)
+		return
+	}
+
+	result, status, err := placeFile(ctx, currentUser(r), source, req, move)
+	var violation *PolicyViolation
+	if errors.As(err, &violation) {
+		writePolicyViolation(w, violation)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// placeFile copies or moves source to the destination of req, whose
+// workspace and folder the caller has already been checked against. It
+// returns the resulting entry and the HTTP status of the outcome; on
+// failure the error is the message to report.
+func placeFile(ctx context.Context, username string, source models.File, req TransferRequest, move bool) (models.File, int, error) {
+	name := req.Name
+	if name == "" {
+		name = source.OriginalFilename
+	}
+	if err := validateName("name", name); err != nil {
+		return models.File{}, http.StatusBadRequest, err
+	}
+	violation, err := checkUploadPolicy(ctx, req.WorkspaceID, name, source.FileType)
+	if err != nil {
+		log.Printf("Error checking upload policy for %s: %v", source.ID, err)
+		return models.File{}, http.StatusInternalServerError, errors.New("Failed to fetch upload policy from database")
+	}
+	if violation != nil {
+		return models.File{}, http.StatusUnsupportedMediaType, violation
+	}
+
+	excludeID := ""
+	if move {
+		excludeID = source.ID
+	}
+	name, replaced, err := resolveNameConflict(ctx, req.WorkspaceID, req.FolderID, name, excludeID, req.OnConflict)
+	if err == errNameConflict {
+		return models.File{}, http.StatusConflict, err
+	}
+	if err != nil {
+		log.Printf("Error checking name conflicts for %s: %v", source.ID, err)
+		return models.File{}, http.StatusInternalServerError, errors.New("Failed to check for name conflicts")
+	}
+
+	var result models.File
+	status := http.StatusOK
+	if move {
+		set := bson.D{
+			{Key: "workspace_id", Value: req.WorkspaceID},
+			{Key: "folder_id", Value: req.FolderID},
+			{Key: "original_filename", Value: name},
+			{Key: "updated_at", Value: time.Now()},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = database.FileCollection.FindOneAndUpdate(ctx, bson.M{"_id": source.ID, "deleted_at": nil}, bson.D{{Key: "$set", Value: set}}, opts).Decode(&result)
+		if err == mongo.ErrNoDocuments {
+			return result, http.StatusNotFound, errors.New("File not found")
+		}
+	} else {
+		// The copy points at the same physical file and hash, so no bytes
+		// are duplicated on disk.
+		result = source
+		result.ID = uuid.New().String()
+		result.WorkspaceID = req.WorkspaceID
+		result.FolderID = req.FolderID
+		result.OriginalFilename = name
+		result.Owner = username
+		result.UploadedAt = time.Now()
+		result.UpdatedAt = result.UploadedAt
+		_, err = database.FileCollection.InsertOne(ctx, result)
+		status = http.StatusCreated
+	}
+	if err != nil {
+		log.Printf("Error transferring file %s: %v", source.ID, err)
+		return result, http.StatusInternalServerError, errors.New("Could not save file metadata")
+	}
+
+	// Only trash overwritten entries once the new entry is in place.
+	for _, old := range replaced {
+		if err := trashFiles(ctx, username, bson.M{"_id": old.ID}); err != nil {
+			log.Printf("Failed to trash overwritten file %s: %v", old.ID, err)
+			continue
+		}
+		audit.Log(username, "file.delete", old.ID, map[string]string{"filename": old.OriginalFilename, "hash": old.Hash, "overwritten_by": result.ID})
+	}
+
+	action := "file.copy"
+	if move {
+		action = "file.move"
+	}
+	audit.Log(username, action, result.ID, map[string]string{
+		"source":       source.ID,
+		"workspace_id": result.WorkspaceID,
+		"folder_id":    result.FolderID,
+		"filename":     result.OriginalFilename,
+	})
+	return result, status, nil
+}
@@ -0,0 +1,336 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"file-hub-go/config"
+	"file-hub-go/imaging"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Fit modes of the transform endpoint.
+const (
+	fitContain = "contain" // Fit inside the box, keeping the aspect ratio
+	fitCover   = "cover"   // Fill the box, keeping the aspect ratio and cropping
+	fitFill    = "fill"    // Stretch to the box
+)
+
+// derivedCache keeps generated image variants on disk within a total size
+// budget, evicting the least recently used ones first. Entries are named
+// after their key, which starts with the hash of the source content.
+type derivedCache struct {
+	mu     sync.Mutex
+	dir    string
+	budget int64
+	size   int64
+	order  *list.List               // Most recently used first
+	items  map[string]*list.Element // Key to element holding a *derivedEntry
+}
+
+type derivedEntry struct {
+	key  string
+	size int64
+}
+
+// transformCache holds the results of the transform endpoint. It is set up
+// by InitTransformCache.
+var transformCache *derivedCache
+
+// newDerivedCache opens the cache in dir, picking up the entries left by a
+// previous run in the order they were last used.
+func newDerivedCache(dir string, budget int64) (*derivedCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &derivedCache{dir: dir, budget: budget, order: list.New(), items: map[string]*list.Element{}}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type stored struct {
+		key  string
+		size int64
+		used time.Time
+	}
+	var found []stored
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), ".tmp-") {
+			// Left over from a write that did not finish.
+			os.Remove(filepath.Join(dir, entry.Name()))
+			continue
+		}
+		found = append(found, stored{entry.Name(), info.Size(), info.ModTime()})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].used.After(found[j].used) })
+	for _, entry := range found {
+		c.items[entry.key] = c.order.PushBack(&derivedEntry{entry.key, entry.size})
+		c.size += entry.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+func (c *derivedCache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// open opens a cached entry and marks it as used. It is done under the
+// lock so that the entry cannot be evicted between finding and opening it;
+// once open, the file can be read even if it is evicted. An entry whose
+// file has gone is dropped and reported as missing.
+func (c *derivedCache) open(key string) (*os.File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	content, err := os.Open(c.path(key))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to open cached image %s: %v", key, err)
+		}
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	// The modification time records the last use across restarts.
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+	return content, true
+}
+
+// add records an entry written to path(key) and evicts the least recently
+// used entries while the cache is over its budget.
+func (c *derivedCache) add(key string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.items[key]; ok {
+		c.size -= element.Value.(*derivedEntry).size
+		c.order.Remove(element)
+	}
+	c.items[key] = c.order.PushFront(&derivedEntry{key, size})
+	c.size += size
+	c.evict()
+}
+
+// evict removes entries from the back until the cache fits its budget.
+// The caller holds mu.
+func (c *derivedCache) evict() {
+	for c.size > c.budget && c.order.Len() > 0 {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove deletes an entry. The caller holds mu.
+func (c *derivedCache) remove(element *list.Element) {
+	entry := element.Value.(*derivedEntry)
+	c.order.Remove(element)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+	if err := os.Remove(c.path(entry.key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Failed to delete cached image %s: %v", entry.key, err)
+	}
+}
+
+// removeHash deletes every entry derived from the content with the given
+// hash.
+func (c *derivedCache) removeHash(hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, element := range c.items {
+		if strings.HasPrefix(key, hash+"-") {
+			c.remove(element)
+		}
+	}
+}
+
+// InitTransformCache opens the cache of transformed images, stored under
+// the upload directory and limited to IMAGE_CACHE_MB.
+func InitTransformCache() {
+	cache, err := newDerivedCache(filepath.Join(config.AppConfig.UploadDir, "derived"), config.AppConfig.TransformCacheSize)
+	if err != nil {
+		log.Fatalf("Could not open the image cache: %v", err)
+	}
+	transformCache = cache
+}
+
+// transformParams is a validated request of the transform endpoint.
+type transformParams struct {
+	Width, Height int // 0 leaves the side to the aspect ratio
+	Fit           string
+	Format        string // "jpeg" or "png"
+}
+
+// key names the result of applying the parameters to content.
+func (p transformParams) key(hash string) string {
+	ext := "png"
+	if p.Format == "jpeg" {
+		ext = "jpg"
+	}
+	return fmt.Sprintf("%s-%dx%d-%s.%s", hash, p.Width, p.Height, p.Fit, ext)
+}
+
+// parseTransformParams reads and checks the query parameters of the
+// transform endpoint. Sizes must be on the IMAGE_TRANSFORM_SIZES list so
+// that only a bounded number of variants can be made of each image.
+func parseTransformParams(r *http.Request, fileType string) (transformParams, error) {
+	query := r.URL.Query()
+	p := transformParams{Fit: query.Get("fit"), Format: query.Get("format")}
+	for _, side := range []struct {
+		name  string
+		value *int
+	}{{"w", &p.Width}, {"h", &p.Height}} {
+		raw := query.Get(side.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || !slices.Contains(config.AppConfig.TransformSizes, n) {
+			return p, fmt.Errorf("%s must be one of %s", side.name, strings.Trim(fmt.Sprint(config.AppConfig.TransformSizes), "[]"))
+		}
+		*side.value = n
+	}
+	if p.Width == 0 && p.Height == 0 {
+		return p, errors.New("w or h is required")
+	}
+
+	switch p.Fit {
+	case "":
+		p.Fit = fitContain
+	case fitContain:
+	case fitCover, fitFill:
+		if p.Width == 0 || p.Height == 0 {
+			return p, fmt.Errorf("fit=%s needs both w and h", p.Fit)
+		}
+	default:
+		return p, fmt.Errorf("fit must be %s, %s or %s", fitContain, fitCover, fitFill)
+	}
+
+	switch p.Format {
+	case "":
+		p.Format = thumbnailFormat(fileType)
+	case "jpeg", "png":
+	case "jpg":
+		p.Format = "jpeg"
+	default:
+		return p, errors.New("format must be jpeg or png")
+	}
+	return p, nil
+}
+
+// transformImage decodes content and applies the parameters to it.
+func transformImage(path string, p transformParams) (image.Image, error) {
+	content, err := os.Open(strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	src, err := imaging.Decode(content)
+	if err != nil {
+		return nil, err
+	}
+	switch p.Fit {
+	case fitCover:
+		return src.Cover(p.Width, p.Height), nil
+	case fitFill:
+		return src.Resize(p.Width, p.Height), nil
+	}
+	w, h := src.Size()
+	return src.Resize(imaging.FitSize(w, h, p.Width, p.Height)), nil
+}
+
+// generateTransform makes a variant of an image, adds it to the cache and
+// opens it. The caller holds decodeMu. The file is opened before it is added,
+// so an immediate eviction cannot pull it out from under the response.
+func generateTransform(path, key string, p transformParams) (*os.File, error) {
+	img, err := transformImage(path, p)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeImageFile(transformCache.path(key), img, p.Format); err != nil {
+		return nil, err
+	}
+	content, err := os.Open(transformCache.path(key))
+	if err != nil {
+		return nil, err
+	}
+	info, err := content.Stat()
+	if err != nil {
+		content.Close()
+		return nil, err
+	}
+	transformCache.add(key, info.Size())
+	return content, nil
+}
+
+// TransformImage serves a JPEG, PNG or GIF file resized, cropped or
+// converted. Parameters: `w` and `h` in pixels from IMAGE_TRANSFORM_SIZES,
+// `fit` (contain, the default, never enlarges; cover crops to fill the box;
+// fill stretches) and `format` (jpeg or png, by default that of the
+// thumbnails). Results are cached by content hash and parameters.
+func TransformImage(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	file, ok := authorizeFile(ctx, w, r, chi.URLParam(r, "id"), false)
+	if !ok || !authorizeDownload(w, file.Scan) {
+		return
+	}
+	if !imaging.Supported(file.FileType) || file.Hash == "" {
+		http.Error(w, "Only JPEG, PNG and GIF images can be transformed", http.StatusBadRequest)
+		return
+	}
+	params, err := parseTransformParams(r, file.FileType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key := params.key(file.Hash)
+	content, cached := transformCache.open(key)
+	if !cached {
+		// Decoding is serialised with thumbnail generation; another
+		// request may have made the same variant in the meantime.
+		decodeMu.Lock()
+		content, cached = transformCache.open(key)
+		if !cached {
+			content, err = generateTransform(file.File, key, params)
+		}
+		decodeMu.Unlock()
+	}
+	if errors.Is(err, imaging.ErrUnsupported) || errors.Is(err, imaging.ErrTooLarge) {
+		http.Error(w, "This image cannot be transformed", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not transform image", http.StatusInternalServerError)
+		log.Printf("Error transforming %s: %v", file.ID, err)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", "image/"+params.Format)
+	w.Header().Set("ETag", `"`+strings.TrimSuffix(key, filepath.Ext(key))+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, "", file.UpdatedAt, content)
+}
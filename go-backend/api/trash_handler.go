@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"file-hub-go/audit"
+	"file-hub-go/config"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// trashFiles moves the files matching filter to the trash.
+func trashFiles(ctx context.Context, username string, filter bson.M) error {
+	filter["deleted_at"] = nil
+	now := time.Now()
+	_, err := database.FileCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+		"deleted_at": now,
+		"deleted_by": username,
+		"updated_at": now,
+	}})
+	return err
+}
+
+// purgeFile removes a trashed file for good and releases the blobs of all
+// its versions.
+func purgeFile(ctx context.Context, actor string, file models.File) error {
+	if _, err := database.FileCollection.DeleteOne(ctx, bson.M{"_id": file.ID}); err != nil {
+		return err
+	}
+	releaseBlob(ctx, file.File, file.Hash)
+	for _, version := range file.Versions {
+		releaseBlob(ctx, version.File, version.Hash)
+	}
+	audit.Log(actor, "file.purge", file.ID, map[string]string{
+		"filename": file.OriginalFilename,
+		"hash":     file.Hash,
+	})
+	return nil
+}
+
+// GetTrash lists the files in the trash of a workspace. It takes the same
+// filter, sort and paging parameters as GetFiles.
+func GetTrash(w http.ResponseWriter, r *http.Request) {
+	listFiles(w, r, true)
+}
+
+// RestoreFile takes a file out of the trash. If its folder has been deleted
+// in the meantime it is restored to the root folder, and if its name has
+// been taken it is renamed to "name (n).ext".
+func RestoreFile(w http.ResponseWriter, r *http.Request) {
+	fileID := chi.URLParam(r, "id")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	file, ok := authorizeTrashedFile(ctx, w, r, fileID, true)
+	if !ok {
+		return
+	}
+
+	folderID := file.FolderID
+	exists, err := folderInWorkspace(ctx, folderID, file.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch folder from database", http.StatusInternalServerError)
+		log.Printf("Error checking folder %s: %v", folderID, err)
+		return
+	}
+	if !exists {
+		folderID = ""
+	}
+	name, _, err := resolveNameConflict(ctx, file.WorkspaceID, folderID, file.OriginalFilename, file.ID, conflictRename)
+	if err != nil {
+		http.Error(w, "Failed to check for name conflicts", http.StatusInternalServerError)
+		log.Printf("Error checking name conflicts for %s: %v", fileID, err)
+		return
+	}
+
+	var restored models.File
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	update := bson.M{
+		"$set": bson.M{
+			"folder_id":         folderID,
+			"original_filename": name,
+			"updated_at":        time.Now(),
+		},
+		"$unset": bson.M{"deleted_at": "", "deleted_by": ""},
+	}
+	err = database.FileCollection.FindOneAndUpdate(ctx, bson.M{"_id": fileID, "deleted_at": bson.M{"$ne": nil}}, update, opts).Decode(&restored)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to restore file", http.StatusInternalServerError)
+		log.Printf("Error restoring file %s: %v", fileID, err)
+		return
+	}
+	audit.Log(currentUser(r), "file.restore", fileID, map[string]string{
+		"filename":  restored.OriginalFilename,
+		"folder_id": restored.FolderID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restored)
+}
+
+// PurgeFile deletes a file in the trash immediately.
+func PurgeFile(w http.ResponseWriter, r *http.Request) {
+	fileID := chi.URLParam(r, "id")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	file, ok := authorizeTrashedFile(ctx, w, r, fileID, true)
+	if !ok {
+		return
+	}
+	if err := purgeFile(ctx, currentUser(r), file); err != nil {
+		http.Error(w, "Failed to delete file", http.StatusInternalServerError)
+		log.Printf("Error purging file %s: %v", fileID, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EmptyTrash purges every file in the trash of the workspace given by
+// `workspace`, or of the default workspace.
+func EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.URL.Query().Get("workspace")
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	if !authorizeWorkspace(ctx, w, r, workspaceID, true) {
+		return
+	}
+	filter := bson.M{"workspace_id": emptyOr(workspaceID), "deleted_at": bson.M{"$ne": nil}}
+	purged, err := purgeMatching(ctx, currentUser(r), filter)
+	if err != nil {
+		http.Error(w, "Failed to empty trash", http.StatusInternalServerError)
+		log.Printf("Error emptying trash of workspace %q: %v", workspaceID, err)
+		return
+	}
+	audit.Log(currentUser(r), "trash.empty", workspaceID, map[string]string{"files": strconv.Itoa(purged)})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgeMatching purges every file matching filter and returns how many were
+// removed.
+func purgeMatching(ctx context.Context, actor string, filter bson.M) (int, error) {
+	var files []models.File
+	cursor, err := database.FileCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"content": 0}))
+	if err == nil {
+		err = cursor.All(ctx, &files)
+	}
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, file := range files {
+		if err := purgeFile(ctx, actor, file); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// StartTrashPurger periodically purges files that have been in the trash for
+// longer than the configured retention period.
+func StartTrashPurger() {
+	go func() {
+		ticker := time.NewTicker(config.AppConfig.TrashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			cutoff := time.Now().Add(-config.AppConfig.TrashRetention)
+			purged, err := purgeMatching(ctx, "system", bson.M{"deleted_at": bson.M{"$ne": nil, "$lt": cutoff}})
+			cancel()
+			if err != nil {
+				log.Printf("Error purging trash: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d file(s) from the trash", purged)
+			}
+			<-ticker.C
+		}
+	}()
+}
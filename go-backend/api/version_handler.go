@@ -0,0 +1,288 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"file-hub-go/audit"
+	"file-hub-go/config"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errVersionConflict = errors.New("the file was changed by someone else, please retry")
+
+// newVersion records the current content of a file as the given version.
+func newVersion(file models.File, number int, username string, at time.Time) models.FileVersion {
+	return models.FileVersion{
+		Version:      number,
+		File:         file.File,
+		Hash:         file.Hash,
+		Size:         file.Size,
+		FileType:     file.FileType,
+		DeclaredType: file.DeclaredType,
+		TypeMismatch: file.TypeMismatch,
+		Scan:         file.Scan,
+		UploadedBy:   username,
+		UploadedAt:   at,
+	}
+}
+
+// fileHistory returns the versions of a file, oldest first. Files uploaded
+// before versioning have a single implicit version.
+func fileHistory(file models.File) []models.FileVersion {
+	if len(file.Versions) > 0 {
+		return file.Versions
+	}
+	return []models.FileVersion{newVersion(file, 1, file.Owner, file.UploadedAt)}
+}
+
+// versionLimit returns how many versions of a file are kept, or 0 to keep
+// every version.
+func versionLimit(file models.File) int {
+	if file.MaxVersions > 0 {
+		return file.MaxVersions
+	}
+	return config.AppConfig.MaxFileVersions
+}
+
+// addVersion makes blob the current content of file as a new version. The
+// oldest versions beyond the file's limit are dropped and their blobs
+// released. It returns errVersionConflict if the file gained a version in
+// the meantime.
+func addVersion(ctx context.Context, file models.File, blob storedBlob, username string) (models.File, error) {
+	history := fileHistory(file)
+	number := history[len(history)-1].Version + 1
+	now := time.Now()
+
+	next := file
+	next.File, next.Hash, next.Size = blob.Path, blob.Hash, blob.Size
+	next.FileType, next.DeclaredType, next.TypeMismatch = blob.FileType, blob.DeclaredType, blob.TypeMismatch
+	next.Scan = blob.Scan
+	versions := append(append([]models.FileVersion{}, history...), newVersion(next, number, username, now))
+	var dropped []models.FileVersion
+	if limit := versionLimit(file); limit > 0 && len(versions) > limit {
+		dropped = versions[:len(versions)-limit]
+		versions = versions[len(versions)-limit:]
+	}
+
+	// Match the version we read so concurrent uploads cannot lose one
+	// another's history. Files from before versioning have no version.
+	filter := bson.M{"_id": file.ID, "deleted_at": nil, "version": file.Version}
+	if file.Version == 0 {
+		filter["version"] = bson.M{"$exists": false}
+	}
+	set := bson.M{
+		"file":          blob.Path,
+		"hash":          blob.Hash,
+		"size":          blob.Size,
+		"file_type":     blob.FileType,
+		"declared_type": blob.DeclaredType,
+		"type_mismatch": blob.TypeMismatch,
+		"scan":          blob.Scan,
+		"content":       blob.Content,
+		"version":       number,
+		"versions":      versions,
+		"updated_at":    now,
+	}
+	var updated models.File
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := database.FileCollection.FindOneAndUpdate(ctx, filter, bson.M{"$set": set}, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return updated, errVersionConflict
+	}
+	if err != nil {
+		return updated, err
+	}
+
+	for _, version := range dropped {
+		releaseBlob(ctx, version.File, version.Hash)
+	}
+	return updated, nil
+}
+
+// fileVersion looks up a version of a file by the `version` URL parameter,
+// writing an error response if there is no such version.
+func fileVersion(w http.ResponseWriter, r *http.Request, file models.File) (models.FileVersion, bool) {
+	number, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err == nil {
+		for _, version := range fileHistory(file) {
+			if version.Version == number {
+				return version, true
+			}
+		}
+	}
+	http.Error(w, "Version not found", http.StatusNotFound)
+	return models.FileVersion{}, false
+}
+
+// writeVersionError writes the error response for a failed addVersion.
+func writeVersionError(w http.ResponseWriter, fileID string, err error) {
+	if err == errVersionConflict {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	http.Error(w, "Could not save file metadata", http.StatusInternalServerError)
+	log.Printf("Error adding version to file %s: %v", fileID, err)
+}
+
+// UploadFileVersion uploads new content for an existing file. The file keeps
+// its ID, name, tags and metadata; the previous content stays available as
+// an earlier version.
+func UploadFileVersion(w http.ResponseWriter, r *http.Request) {
+	fileID := chi.URLParam(r, "id")
+	upload, handler, ok := uploadedFile(w, r)
+	if !ok {
+		return
+	}
+	defer upload.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	file, ok := authorizeFile(ctx, w, r, fileID, true)
+	if !ok {
+		return
+	}
+	fileType, _, _ := detectType(upload, file.OriginalFilename, handler.Header.Get("Content-Type"))
+	if !enforceUploadPolicy(ctx, w, file.WorkspaceID, file.OriginalFilename, fileType) {
+		return
+	}
+	blob, err := storeBlob(ctx, upload, handler.Size, file.OriginalFilename, handler.Header.Get("Content-Type"), blobOwner{currentUser(r), file.WorkspaceID})
+	if err != nil {
+		writeStoreError(w, "new version of "+fileID, err)
+		return
+	}
+	updated, err := addVersion(ctx, file, blob, currentUser(r))
+	if err != nil {
+		// Nothing references the new content yet unless another file
+		// has the same hash, so drop it again.
+		releaseBlob(ctx, blob.Path, blob.Hash)
+		writeVersionError(w, fileID, err)
+		return
+	}
+	queueScan()
+	queueThumbnails(blob)
+	audit.Log(currentUser(r), "file.version", fileID, map[string]string{
+		"version": strconv.Itoa(updated.Version),
+		"hash":    updated.Hash,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(updated)
+}
+
+// GetFileVersions lists the versions of a file, newest first.
+func GetFileVersions(w http.ResponseWriter, r *http.Request) {
+	fileID := chi.URLParam(r, "id")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	file, ok := authorizeFile(ctx, w, r, fileID, false)
+	if !ok {
+		return
+	}
+	history := fileHistory(file)
+	versions := make([]models.FileVersion, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		versions = append(versions, history[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// DownloadFileVersion sends the content of one version of a file.
+func DownloadFileVersion(w http.ResponseWriter, r *http.Request) {
+	fileID := chi.URLParam(r, "id")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	file, ok := authorizeFile(ctx, w, r, fileID, false)
+	if !ok {
+		return
+	}
+	version, ok := fileVersion(w, r, file)
+	if !ok || !authorizeDownload(w, version.Scan) {
+		return
+	}
+
+	physicalPath := strings.TrimPrefix(version.File, "/")
+	content, err := os.Open(physicalPath)
+	if err != nil {
+		http.Error(w, "File content not found", http.StatusNotFound)
+		log.Printf("Error opening %s: %v", physicalPath, err)
+		return
+	}
+	defer content.Close()
+
+	if version.FileType != "" {
+		w.Header().Set("Content-Type", version.FileType)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.OriginalFilename}))
+	http.ServeContent(w, r, file.OriginalFilename, version.UploadedAt, content)
+}
+
+// RestoreFileVersion makes the content of an earlier version current again.
+// The restored content is added as a new version, so no history is lost.
+func RestoreFileVersion(w http.ResponseWriter, r *http.Request) {
+	fileID := chi.URLParam(r, "id")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	file, ok := authorizeFile(ctx, w, r, fileID, true)
+	if !ok {
+		return
+	}
+	version, ok := fileVersion(w, r, file)
+	if !ok {
+		return
+	}
+	if history := fileHistory(file); version.Version == history[len(history)-1].Version {
+		http.Error(w, "This version is already the current version", http.StatusBadRequest)
+		return
+	}
+
+	// Only the current version's text is kept, so extract it again.
+	blob := storedBlob{
+		Path:         version.File,
+		Hash:         version.Hash,
+		Size:         version.Size,
+		FileType:     version.FileType,
+		DeclaredType: version.DeclaredType,
+		TypeMismatch: version.TypeMismatch,
+		Scan:         version.Scan,
+	}
+	if content, err := os.Open(strings.TrimPrefix(version.File, "/")); err == nil {
+		blob.Content = extractContent(content, version.Size, file.OriginalFilename, version.FileType)
+		content.Close()
+	} else {
+		log.Printf("Error opening version %d of %s: %v", version.Version, fileID, err)
+	}
+
+	updated, err := addVersion(ctx, file, blob, currentUser(r))
+	if err != nil {
+		writeVersionError(w, fileID, err)
+		return
+	}
+	audit.Log(currentUser(r), "file.version_restore", fileID, map[string]string{
+		"restored": strconv.Itoa(version.Version),
+		"version":  strconv.Itoa(updated.Version),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
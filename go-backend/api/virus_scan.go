@@ -0,0 +1,279 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"file-hub-go/audit"
+	"file-hub-go/config"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"file-hub-go/scan"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// scanBatchSize is how many files the background scanner loads at a time.
+const scanBatchSize = 100
+
+// virusScanner is set by StartVirusScanner unless SCAN_MODE is off.
+var virusScanner scan.Scanner
+
+// scanQueue wakes the background scanner when uploads are waiting in
+// quarantine.
+var scanQueue = make(chan struct{}, 1)
+
+// infectedError is an upload the virus scanner flagged.
+type infectedError struct{ threat string }
+
+func (e infectedError) Error() string {
+	return fmt.Sprintf("The file is infected with %s", e.threat)
+}
+
+// scanContent scans content and returns the result to record. A result
+// without signatures means the scanner could not be asked and the content
+// should be scanned again later. Content the scanner is up but fails on,
+// for example because it is too large or takes too long, is recorded as
+// failed with the current signatures, so it is not retried until they
+// change and does not hold up the content queued after it.
+func scanContent(ctx context.Context, r io.Reader) *models.ScanResult {
+	now := time.Now()
+	signatures, err := virusScanner.Signatures(ctx)
+	if err != nil {
+		log.Printf("Virus scanner unavailable: %v", err)
+		return &models.ScanResult{Status: models.ScanFailed, ScannedAt: &now}
+	}
+	verdict, err := virusScanner.Scan(ctx, r)
+	switch {
+	case errors.Is(err, scan.ErrSizeLimit):
+		return &models.ScanResult{Status: models.ScanFailed, Signatures: signatures, ScannedAt: &now}
+	case err != nil:
+		log.Printf("Virus scan failed: %v", err)
+		if _, err := virusScanner.Signatures(ctx); err != nil {
+			log.Printf("Virus scanner unavailable: %v", err)
+			return &models.ScanResult{Status: models.ScanFailed, ScannedAt: &now}
+		}
+		return &models.ScanResult{Status: models.ScanFailed, Signatures: signatures, ScannedAt: &now}
+	case verdict.Infected:
+		return &models.ScanResult{Status: models.ScanInfected, Threat: verdict.Threat, Signatures: signatures, ScannedAt: &now}
+	}
+	return &models.ScanResult{Status: models.ScanClean, Signatures: signatures, ScannedAt: &now}
+}
+
+// scanUpload returns the scan result for new content. Inline scanning
+// returns infectedError for infected content; asynchronous scanning marks
+// the content pending, so the caller must call queueScan once it is saved.
+func scanUpload(ctx context.Context, src io.ReaderAt, size int64) (*models.ScanResult, error) {
+	switch config.AppConfig.ScanMode {
+	case config.ScanAsync:
+		return &models.ScanResult{Status: models.ScanPending}, nil
+	case config.ScanInline:
+		result := scanContent(ctx, io.NewSectionReader(src, 0, size))
+		if result.Status == models.ScanInfected {
+			return result, infectedError{result.Threat}
+		}
+		return result, nil
+	}
+	return nil, nil
+}
+
+// queueScan wakes the background scanner.
+func queueScan() {
+	if config.AppConfig.ScanMode != config.ScanAsync {
+		return
+	}
+	select {
+	case scanQueue <- struct{}{}:
+	default: // Already queued
+	}
+}
+
+// writeStoreError writes the response for a failed storeBlob.
+func writeStoreError(w http.ResponseWriter, filename string, err error) {
+	var infected infectedError
+	if errors.As(err, &infected) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		log.Printf("Rejected upload %s: %v", filename, err)
+		return
+	}
+	http.Error(w, "Could not save file", http.StatusInternalServerError)
+	log.Printf("Error storing %s: %v", filename, err)
+}
+
+// downloadBlocked returns why content with the given scan result may not be
+// downloaded, or "" if it may. Infected and quarantined content is always
+// blocked; SCAN_BLOCK_DOWNLOADS=unscanned only serves clean content.
+func downloadBlocked(result *models.ScanResult) string {
+	switch {
+	case result != nil && result.Status == models.ScanInfected:
+		return fmt.Sprintf("The file is infected with %s and cannot be downloaded", result.Threat)
+	case result != nil && result.Status == models.ScanPending:
+		return "The file is quarantined until its virus scan is done"
+	case config.AppConfig.ScanDownloads == config.ScanBlockUnscanned && (result == nil || result.Status != models.ScanClean):
+		return "The file has not been found clean by a virus scan"
+	}
+	return ""
+}
+
+// authorizeDownload writes an error response and returns false if content
+// with the given scan result may not be downloaded.
+func authorizeDownload(w http.ResponseWriter, result *models.ScanResult) bool {
+	if reason := downloadBlocked(result); reason != "" {
+		http.Error(w, reason, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// recordScan stores the result of scanning a physical file on every file
+// and version that references it.
+func recordScan(ctx context.Context, path string, result *models.ScanResult) error {
+	if _, err := database.FileCollection.UpdateMany(ctx, bson.M{"file": path}, bson.M{"$set": bson.M{"scan": result}}); err != nil {
+		return err
+	}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"v.file": path}}})
+	_, err := database.FileCollection.UpdateMany(ctx, bson.M{"versions.file": path}, bson.M{"$set": bson.M{"versions.$[v].scan": result}}, opts)
+	if err != nil || result.Status != models.ScanInfected {
+		return err
+	}
+
+	ids, err := database.FileCollection.Distinct(ctx, "_id", bson.M{"$or": bson.A{bson.M{"file": path}, bson.M{"versions.file": path}}})
+	if err != nil {
+		return err
+	}
+	log.Printf("Virus scan found %s in %s, used by %d file(s)", result.Threat, path, len(ids))
+	for _, id := range ids {
+		audit.Log("system", "file.infected", fmt.Sprint(id), map[string]string{"threat": result.Threat})
+	}
+	return nil
+}
+
+// scanBlobs scans the physical files of the files matching filter whose
+// content, current or of a version, was not scanned with the given
+// signatures. It stops when the scanner becomes unavailable or its
+// signatures change, leaving the rest for the next run.
+func scanBlobs(ctx context.Context, filter bson.M, signatures string) (int, error) {
+	outdated := func(result *models.ScanResult) bool {
+		return result == nil || result.Signatures != signatures
+	}
+	query := bson.M{
+		"deleted_at": nil,
+		"$and": bson.A{filter, bson.M{"$or": bson.A{
+			bson.M{"scan.signatures": bson.M{"$ne": signatures}},
+			bson.M{"versions": bson.M{"$elemMatch": bson.M{"scan.signatures": bson.M{"$ne": signatures}}}},
+		}}},
+	}
+	opts := options.Find().
+		SetProjection(bson.M{"file": 1, "scan": 1, "versions.file": 1, "versions.scan": 1}).
+		SetLimit(scanBatchSize)
+
+	scanned := 0
+	for {
+		var files []models.File
+		cursor, err := database.FileCollection.Find(ctx, query, opts)
+		if err == nil {
+			err = cursor.All(ctx, &files)
+		}
+		if err != nil || len(files) == 0 {
+			return scanned, err
+		}
+
+		paths := map[string]bool{}
+		for _, file := range files {
+			if outdated(file.Scan) {
+				paths[file.File] = true
+			}
+			for _, version := range file.Versions {
+				if outdated(version.Scan) {
+					paths[version.File] = true
+				}
+			}
+		}
+		for path := range paths {
+			var result *models.ScanResult
+			content, err := os.Open(strings.TrimPrefix(path, "/"))
+			if err != nil {
+				// Missing content cannot be scanned; record that so it is
+				// not retried until the signatures change.
+				log.Printf("Error opening %s for a virus scan: %v", path, err)
+				now := time.Now()
+				result = &models.ScanResult{Status: models.ScanFailed, Signatures: signatures, ScannedAt: &now}
+			} else {
+				result = scanContent(ctx, content)
+				content.Close()
+			}
+			if result.Signatures != signatures {
+				if result.Signatures == "" {
+					return scanned, errors.New("virus scanner unavailable")
+				}
+				return scanned, nil
+			}
+			if err := recordScan(ctx, path, result); err != nil {
+				return scanned, err
+			}
+			scanned++
+		}
+	}
+}
+
+// StartVirusScanner sets up the clamd scanner and starts the background
+// scanner, which scans quarantined uploads as they arrive and rescans all
+// content whenever the signatures are updated. It does nothing when
+// SCAN_MODE is off.
+func StartVirusScanner() {
+	if config.AppConfig.ScanMode == config.ScanOff {
+		return
+	}
+	clamd, err := scan.NewClamd(config.AppConfig.ClamdAddress, config.AppConfig.ScanTimeout)
+	if err != nil {
+		log.Fatalf("Invalid CLAMD_ADDRESS: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := clamd.Ping(ctx); err != nil {
+		log.Printf("Virus scanner at %s is not reachable yet: %v", config.AppConfig.ClamdAddress, err)
+	}
+	cancel()
+	virusScanner = clamd
+
+	go func() {
+		ticker := time.NewTicker(config.AppConfig.ScanInterval)
+		defer ticker.Stop()
+		last := ""
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+			signatures, err := virusScanner.Signatures(ctx)
+			if err == nil {
+				if last != "" && signatures != last {
+					log.Printf("Virus signatures updated to %s, rescanning", signatures)
+				}
+				last = signatures
+				// Quarantined uploads go first so they are not held up by a
+				// rescan.
+				pending := bson.M{"$or": bson.A{bson.M{"scan.status": models.ScanPending}, bson.M{"versions.scan.status": models.ScanPending}}}
+				var scanned, rescanned int
+				scanned, err = scanBlobs(ctx, pending, signatures)
+				if err == nil {
+					rescanned, err = scanBlobs(ctx, bson.M{}, signatures)
+				}
+				if scanned+rescanned > 0 {
+					log.Printf("Scanned %d file(s) for viruses", scanned+rescanned)
+				}
+			}
+			cancel()
+			if err != nil {
+				log.Printf("Error running virus scans: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-scanQueue:
+			}
+		}
+	}()
+}
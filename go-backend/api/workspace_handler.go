@@ -0,0 +1,306 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"file-hub-go/audit"
+	"file-hub-go/config"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// emptyOr returns the value to match an optional string field against.
+// Documents written before the field existed have no value at all, so an
+// empty value also matches a missing field.
+func emptyOr(value string) interface{} {
+	if value == "" {
+		return bson.M{"$in": bson.A{"", nil}}
+	}
+	return value
+}
+
+// workspaceRole returns the caller's role in a workspace, or "" if they are
+// not a member. Everybody is an editor of the default workspace.
+func workspaceRole(ctx context.Context, username, workspaceID string) (string, error) {
+	if workspaceID == "" {
+		return models.RoleEditor, nil
+	}
+
+	var workspace models.Workspace
+	err := database.WorkspaceCollection.FindOne(ctx, bson.M{"_id": workspaceID}).Decode(&workspace)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if workspace.Owner == username {
+		return models.RoleOwner, nil
+	}
+	for _, member := range workspace.Members {
+		if member.Username == username {
+			return member.Role, nil
+		}
+	}
+	return "", nil
+}
+
+// canWrite reports whether a role may add, change or remove content.
+func canWrite(role string) bool {
+	return role == models.RoleOwner || role == models.RoleEditor
+}
+
+// isAdmin reports whether a user is an instance administrator, i.e. is
+// listed in ADMIN_USERS.
+func isAdmin(username string) bool {
+	return slices.Contains(config.AppConfig.AdminUsers, username)
+}
+
+// authorizeWorkspace checks the caller's access to a workspace and writes an
+// error response if it is insufficient. Workspaces the caller cannot see at
+// all are reported as not found.
+func authorizeWorkspace(ctx context.Context, w http.ResponseWriter, r *http.Request, workspaceID string, write bool) bool {
+	role, err := workspaceRole(ctx, currentUser(r), workspaceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch workspace from database", http.StatusInternalServerError)
+		log.Printf("Error fetching workspace %s: %v", workspaceID, err)
+		return false
+	}
+	if role == "" {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return false
+	}
+	if write && !canWrite(role) {
+		http.Error(w, "You do not have write access to this workspace", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// authorizeWorkspaceOwner checks that the caller may change a setting that
+// applies to everybody in a workspace, writing an error response if not.
+// That is the owner, or an admin for the default workspace, which has no
+// owner and which everybody can edit.
+func authorizeWorkspaceOwner(ctx context.Context, w http.ResponseWriter, r *http.Request, workspaceID, setting string) bool {
+	username := currentUser(r)
+	role, err := workspaceRole(ctx, username, workspaceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch workspace from database", http.StatusInternalServerError)
+		log.Printf("Error fetching workspace %s: %v", workspaceID, err)
+		return false
+	}
+	if role == "" {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return false
+	}
+	if workspaceID == "" && !isAdmin(username) {
+		http.Error(w, fmt.Sprintf("Only admins can change the %s of the default workspace", setting), http.StatusForbidden)
+		return false
+	}
+	if workspaceID != "" && role != models.RoleOwner {
+		http.Error(w, fmt.Sprintf("Only the workspace owner can change its %s", setting), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// authorizeFile loads a file that is not in the trash and checks the caller's
+// access to its workspace, writing an error response on failure.
+func authorizeFile(ctx context.Context, w http.ResponseWriter, r *http.Request, fileID string, write bool) (models.File, bool) {
+	return authorizeFileMatching(ctx, w, r, bson.M{"_id": fileID, "deleted_at": nil}, write)
+}
+
+// authorizeTrashedFile is authorizeFile for files in the trash.
+func authorizeTrashedFile(ctx context.Context, w http.ResponseWriter, r *http.Request, fileID string, write bool) (models.File, bool) {
+	return authorizeFileMatching(ctx, w, r, bson.M{"_id": fileID, "deleted_at": bson.M{"$ne": nil}}, write)
+}
+
+func authorizeFileMatching(ctx context.Context, w http.ResponseWriter, r *http.Request, filter bson.M, write bool) (models.File, bool) {
+	fileID := filter["_id"]
+	var file models.File
+	err := database.FileCollection.FindOne(ctx, filter).Decode(&file)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return file, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch file from database", http.StatusInternalServerError)
+		log.Printf("Error fetching file %s: %v", fileID, err)
+		return file, false
+	}
+	role, err := workspaceRole(ctx, currentUser(r), file.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch workspace from database", http.StatusInternalServerError)
+		log.Printf("Error fetching workspace %s: %v", file.WorkspaceID, err)
+		return file, false
+	}
+	if role == "" {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return file, false
+	}
+	if write && !canWrite(role) {
+		http.Error(w, "You do not have write access to this file", http.StatusForbidden)
+		return file, false
+	}
+	return file, true
+}
+
+// authorizeFolder loads a folder and checks the caller's access to its
+// workspace, writing an error response on failure.
+func authorizeFolder(ctx context.Context, w http.ResponseWriter, r *http.Request, folderID string, write bool) (models.Folder, bool) {
+	var folder models.Folder
+	err := database.FolderCollection.FindOne(ctx, bson.M{"_id": folderID}).Decode(&folder)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return folder, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch folder from database", http.StatusInternalServerError)
+		log.Printf("Error fetching folder %s: %v", folderID, err)
+		return folder, false
+	}
+	role, err := workspaceRole(ctx, currentUser(r), folder.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch workspace from database", http.StatusInternalServerError)
+		log.Printf("Error fetching workspace %s: %v", folder.WorkspaceID, err)
+		return folder, false
+	}
+	if role == "" {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return folder, false
+	}
+	if write && !canWrite(role) {
+		http.Error(w, "You do not have write access to this folder", http.StatusForbidden)
+		return folder, false
+	}
+	return folder, true
+}
+
+// folderInWorkspace reports whether a folder exists in the given workspace.
+// The root ("") is in every workspace.
+func folderInWorkspace(ctx context.Context, folderID, workspaceID string) (bool, error) {
+	if folderID == "" {
+		return true, nil
+	}
+	count, err := database.FolderCollection.CountDocuments(ctx, bson.M{"_id": folderID, "workspace_id": emptyOr(workspaceID)})
+	return count > 0, err
+}
+
+// CreateWorkspace creates a workspace owned by the caller.
+func CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateName("name", req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	workspace := models.Workspace{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Owner:     currentUser(r),
+		Members:   []models.WorkspaceMember{},
+		CreatedAt: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := database.WorkspaceCollection.InsertOne(ctx, workspace); err != nil {
+		http.Error(w, "Could not create workspace", http.StatusInternalServerError)
+		log.Printf("Error creating workspace: %v", err)
+		return
+	}
+	audit.Log(workspace.Owner, "workspace.create", workspace.ID, map[string]string{"name": workspace.Name})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(workspace)
+}
+
+// GetWorkspaces lists the workspaces the caller owns or is a member of.
+func GetWorkspaces(w http.ResponseWriter, r *http.Request) {
+	username := currentUser(r)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"owner": username},
+		bson.M{"members.username": username},
+	}}
+	cursor, err := database.WorkspaceCollection.Find(ctx, filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch workspaces from database", http.StatusInternalServerError)
+		log.Printf("Error fetching workspaces: %v", err)
+		return
+	}
+	workspaces := []models.Workspace{}
+	if err := cursor.All(ctx, &workspaces); err != nil {
+		http.Error(w, "Failed to decode workspaces", http.StatusInternalServerError)
+		log.Printf("Error decoding workspaces: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspaces)
+}
+
+// SetWorkspaceMember adds a member to a workspace or changes their role. Only
+// the owner may manage members.
+func SetWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	workspaceID := chi.URLParam(r, "id")
+
+	var member models.WorkspaceMember
+	if err := json.NewDecoder(r.Body).Decode(&member); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if member.Username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+	if member.Role != models.RoleEditor && member.Role != models.RoleViewer {
+		http.Error(w, "role must be editor or viewer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	username := currentUser(r)
+	filter := bson.M{"_id": workspaceID, "owner": username}
+	// Replace any existing membership so a user only ever has one role.
+	_, err := database.WorkspaceCollection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"members": bson.M{"username": member.Username}}})
+	if err == nil {
+		var res *mongo.UpdateResult
+		res, err = database.WorkspaceCollection.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"members": member}})
+		if err == nil && res.MatchedCount == 0 {
+			http.Error(w, "Workspace not found", http.StatusNotFound)
+			return
+		}
+	}
+	if err != nil {
+		http.Error(w, "Failed to update workspace", http.StatusInternalServerError)
+		log.Printf("Error updating members of workspace %s: %v", workspaceID, err)
+		return
+	}
+	audit.Log(username, "workspace.member", workspaceID, map[string]string{"username": member.Username, "role": member.Role})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"file-hub-go/config"
+	"file-hub-go/database"
+	"file-hub-go/models"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxZipEntries bounds the number of files in one archive.
+const maxZipEntries = 10000
+
+// zipEntry is a file and its path inside the archive.
+type zipEntry struct {
+	Name string
+	File models.File
+}
+
+// zipNames hands out archive paths that are unique within their directory.
+// Names are compared case-insensitively because many file systems that the
+// archive is extracted on are.
+type zipNames map[string]bool
+
+// unique returns name in dir, or "name (n).ext" if that is already taken.
+func (used zipNames) unique(dir, name string) string {
+	// Uploads from before name validation may contain separators.
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := path.Join(dir, name)
+	for n := 1; used[strings.ToLower(candidate)]; n++ {
+		candidate = path.Join(dir, fmt.Sprintf("%s (%d)%s", base, n, ext))
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// storedTypes are content types that are already compressed and are stored
+// in the archive as they are.
+var storedTypes = []string{"image/", "video/", "audio/", "application/zip", "application/gzip", "application/x-7z-compressed", "application/vnd.openxmlformats-officedocument."}
+
+func zipMethod(fileType string) uint16 {
+	for _, prefix := range storedTypes {
+		if strings.HasPrefix(fileType, prefix) && fileType != "image/svg+xml" && fileType != "image/bmp" {
+			return zip.Store
+		}
+	}
+	return zip.Deflate
+}
+
+// DownloadZip streams a ZIP archive of several files or of a folder. Pass
+// either `ids` (comma-separated or repeated) for a flat archive of those
+// files, or `folder` for a folder and all its subfolders with their
+// structure preserved; `folder=root` together with `workspace` archives a
+// whole workspace. The archive is written as it is read from disk and never
+// held in memory or in a temporary file.
+func DownloadZip(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	var ids []string
+	for _, value := range params["ids"] {
+		for _, id := range strings.Split(value, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	folderID := params.Get("folder")
+	if (len(ids) == 0) == (folderID == "") {
+		http.Error(w, "Provide either ids or folder", http.StatusBadRequest)
+		return
+	}
+	if len(ids) > maxZipEntries {
+		http.Error(w, fmt.Sprintf("At most %d files can be downloaded at once", maxZipEntries), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var entries []zipEntry
+	var dirs []string
+	archiveName := "files"
+	var ok bool
+	if folderID != "" {
+		entries, dirs, archiveName, ok = folderZipEntries(ctx, w, r, folderID, params.Get("workspace"))
+	} else {
+		entries, ok = fileZipEntries(ctx, w, r, ids)
+	}
+	if !ok {
+		return
+	}
+	if len(entries) > maxZipEntries {
+		http.Error(w, fmt.Sprintf("At most %d files can be downloaded at once", maxZipEntries), http.StatusBadRequest)
+		return
+	}
+
+	var total int64
+	for _, entry := range entries {
+		if reason := downloadBlocked(entry.File.Scan); reason != "" {
+			http.Error(w, fmt.Sprintf("%s: %s", entry.Name, reason), http.StatusForbidden)
+			return
+		}
+		total += entry.File.Size
+	}
+	if total > config.AppConfig.MaxZipSize {
+		http.Error(w, fmt.Sprintf("The selected files are too large to download together. The limit is %dMB.", config.AppConfig.MaxZipSize/1024/1024), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": archiveName + ".zip"}))
+
+	zw := zip.NewWriter(w)
+	for _, dir := range dirs {
+		if _, err := zw.CreateHeader(&zip.FileHeader{Name: dir + "/", Modified: time.Now()}); err != nil {
+			log.Printf("Error writing ZIP archive: %v", err)
+			return
+		}
+	}
+	for _, entry := range entries {
+		if err := writeZipEntry(zw, entry); err != nil {
+			// The response has started, so all we can do is cut it short;
+			// the client sees a truncated archive.
+			log.Printf("Error writing %s to ZIP archive: %v", entry.File.ID, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Error finishing ZIP archive: %v", err)
+	}
+}
+
+func writeZipEntry(zw *zip.Writer, entry zipEntry) error {
+	physicalPath := strings.TrimPrefix(entry.File.File, "/")
+	src, err := os.Open(physicalPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     entry.Name,
+		Method:   zipMethod(entry.File.FileType),
+		Modified: entry.File.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// fileZipEntries loads the requested files for a flat archive. Files the
+// caller cannot read are reported as not found.
+func fileZipEntries(ctx context.Context, w http.ResponseWriter, r *http.Request, ids []string) ([]zipEntry, bool) {
+	var files []models.File
+	opts := options.Find().SetProjection(bson.M{"content": 0, "versions": 0})
+	cursor, err := database.FileCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "deleted_at": nil}, opts)
+	if err == nil {
+		err = cursor.All(ctx, &files)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch files from database", http.StatusInternalServerError)
+		log.Printf("Error fetching files for ZIP download: %v", err)
+		return nil, false
+	}
+
+	byID := map[string]models.File{}
+	roles := map[string]string{}
+	for _, file := range files {
+		role, ok := roles[file.WorkspaceID]
+		if !ok {
+			if role, err = workspaceRole(ctx, currentUser(r), file.WorkspaceID); err != nil {
+				http.Error(w, "Failed to fetch workspace from database", http.StatusInternalServerError)
+				return nil, false
+			}
+			roles[file.WorkspaceID] = role
+		}
+		if role != "" {
+			byID[file.ID] = file
+		}
+	}
+
+	used := zipNames{}
+	seen := map[string]bool{}
+	entries := []zipEntry{}
+	for _, id := range ids {
+		file, ok := byID[id]
+		if !ok {
+			http.Error(w, fmt.Sprintf("File not found: %s", id), http.StatusNotFound)
+			return nil, false
+		}
+		if !seen[id] {
+			seen[id] = true
+			entries = append(entries, zipEntry{Name: used.unique("", file.OriginalFilename), File: file})
+		}
+	}
+	return entries, true
+}
+
+// folderZipEntries loads a folder's files and subfolders with their paths
+// relative to the folder. It also returns the directories so that empty
+// ones are kept, and the name to give the archive.
+func folderZipEntries(ctx context.Context, w http.ResponseWriter, r *http.Request, folderID, workspaceID string) ([]zipEntry, []string, string, bool) {
+	var folderFilter bson.M
+	archiveName := "files"
+	if folderID == rootFolder {
+		if !authorizeWorkspace(ctx, w, r, workspaceID, false) {
+			return nil, nil, "", false
+		}
+		folderFilter = bson.M{"workspace_id": emptyOr(workspaceID)}
+		folderID = ""
+	} else {
+		folder, ok := authorizeFolder(ctx, w, r, folderID, false)
+		if !ok {
+			return nil, nil, "", false
+		}
+		workspaceID = folder.WorkspaceID
+		archiveName = folder.Name
+		ids, err := descendantFolderIDs(ctx, folderID)
+		if err != nil {
+			http.Error(w, "Failed to fetch folders from database", http.StatusInternalServerError)
+			log.Printf("Error collecting subfolders of %s: %v", folderID, err)
+			return nil, nil, "", false
+		}
+		folderFilter = bson.M{"_id": bson.M{"$in": ids}}
+	}
+
+	var folders []models.Folder
+	cursor, err := database.FolderCollection.Find(ctx, folderFilter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err == nil {
+		err = cursor.All(ctx, &folders)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch folders from database", http.StatusInternalServerError)
+		log.Printf("Error fetching folders for ZIP download: %v", err)
+		return nil, nil, "", false
+	}
+	byID := map[string]models.Folder{}
+	for _, folder := range folders {
+		byID[folder.ID] = folder
+	}
+
+	// Resolve each folder's path below the archived folder, giving it a
+	// name that is unique in its parent directory.
+	used := zipNames{}
+	paths := map[string]string{folderID: ""}
+	var pathOf func(id string, depth int) string
+	pathOf = func(id string, depth int) string {
+		if p, ok := paths[id]; ok {
+			return p
+		}
+		folder, ok := byID[id]
+		if !ok || depth > maxFolderDepth {
+			return ""
+		}
+		p := used.unique(pathOf(folder.ParentID, depth+1), folder.Name)
+		paths[id] = p
+		return p
+	}
+	dirs := []string{}
+	var folderIDs []string
+	for _, folder := range folders {
+		if folder.ID == folderID {
+			folderIDs = append(folderIDs, folder.ID)
+			continue
+		}
+		dirs = append(dirs, pathOf(folder.ID, 0))
+		folderIDs = append(folderIDs, folder.ID)
+	}
+
+	fileFilter := bson.M{"workspace_id": emptyOr(workspaceID), "deleted_at": nil}
+	if folderID != "" {
+		fileFilter["folder_id"] = bson.M{"$in": folderIDs}
+	}
+	var files []models.File
+	// One more than the limit is enough to tell that it is exceeded.
+	opts := options.Find().
+		SetProjection(bson.M{"content": 0, "versions": 0}).
+		SetSort(bson.D{{Key: "original_filename", Value: 1}}).
+		SetLimit(maxZipEntries + 1)
+	cursor, err = database.FileCollection.Find(ctx, fileFilter, opts)
+	if err == nil {
+		err = cursor.All(ctx, &files)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch files from database", http.StatusInternalServerError)
+		log.Printf("Error fetching files for ZIP download: %v", err)
+		return nil, nil, "", false
+	}
+
+	entries := []zipEntry{}
+	for _, file := range files {
+		dir := pathOf(file.FolderID, 0)
+		entries = append(entries, zipEntry{Name: used.unique(dir, file.OriginalFilename), File: file})
+	}
+	return entries, dirs, archiveName, true
+}
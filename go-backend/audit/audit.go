@@ -0,0 +1,230 @@
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"file-hub-go/config"
+	"file-hub-go/database"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// genesisHash is the "previous hash" of the very first record in the chain.
+var genesisHash = strings.Repeat("0", 64)
+
+// Record is a single entry in the audit log. Every record carries the hash of
+// the record before it, so editing, deleting or reordering any record breaks
+// the chain from that point onwards.
+type Record struct {
+	Seq       int64             `bson:"_id" json:"seq"`
+	Timestamp time.Time         `bson:"timestamp" json:"timestamp"`
+	Actor     string            `bson:"actor" json:"actor"`
+	Action    string            `bson:"action" json:"action"`
+	Target    string            `bson:"target" json:"target"`
+	Details   map[string]string `bson:"details,omitempty" json:"details,omitempty"`
+	PrevHash  string            `bson:"prev_hash" json:"prev_hash"`
+	Hash      string            `bson:"hash" json:"hash"`
+}
+
+// Checkpoint pins the hash of the record at Seq with a signature made with
+// the server key. Someone who rewrites the whole chain from scratch still
+// cannot produce valid checkpoints without that key.
+type Checkpoint struct {
+	Seq       int64     `bson:"_id" json:"seq"`
+	Hash      string    `bson:"hash" json:"hash"`
+	Signature string    `bson:"signature" json:"signature"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// BrokenLinkError describes the first point at which the chain fails to verify.
+type BrokenLinkError struct {
+	Seq    int64
+	Reason string
+}
+
+func (e *BrokenLinkError) Error() string {
+	return fmt.Sprintf("audit chain broken at record %d: %s", e.Seq, e.Reason)
+}
+
+// mu serializes appends from this process so each record sees the latest tail.
+var mu sync.Mutex
+
+// computeHash returns the SHA-256 of the record's contents and its PrevHash.
+// The Hash field itself is not part of the input.
+func computeHash(rec Record) string {
+	details := rec.Details
+	if len(details) == 0 {
+		details = nil
+	}
+	// encoding/json writes struct fields in declaration order and sorts map
+	// keys, which gives us a stable serialization to hash.
+	payload, _ := json.Marshal(struct {
+		Seq       int64             `json:"seq"`
+		Timestamp string            `json:"timestamp"`
+		Actor     string            `json:"actor"`
+		Action    string            `json:"action"`
+		Target    string            `json:"target"`
+		Details   map[string]string `json:"details"`
+		PrevHash  string            `json:"prev_hash"`
+	}{rec.Seq, rec.Timestamp.UTC().Format(time.RFC3339Nano), rec.Actor, rec.Action, rec.Target, details, rec.PrevHash})
+
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign returns the HMAC-SHA256 of a checkpoint using the server key.
+func sign(seq int64, hash string) string {
+	mac := hmac.New(sha256.New, []byte(config.AppConfig.AuditSigningKey))
+	fmt.Fprintf(mac, "%d:%s", seq, hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Log appends a new record to the audit chain. Failures are logged rather than
+// returned so that auditing never breaks the request being audited.
+func Log(actor, action, target string, details map[string]string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := appendRecord(ctx, actor, action, target, details); err != nil {
+		log.Printf("Failed to write audit record for %s on %s: %v", action, target, err)
+	}
+}
+
+func appendRecord(ctx context.Context, actor, action, target string, details map[string]string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// The sequence number doubles as the document _id, so if another server
+	// appends at the same time one of the inserts fails with a duplicate key
+	// and we simply retry against the new tail.
+	for attempt := 0; attempt < 5; attempt++ {
+		rec := Record{
+			Seq:       1,
+			Timestamp: time.Now().UTC().Truncate(time.Millisecond), // MongoDB stores milliseconds
+			Actor:     actor,
+			Action:    action,
+			Target:    target,
+			Details:   details,
+			PrevHash:  genesisHash,
+		}
+
+		var last Record
+		opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})
+		err := database.AuditCollection.FindOne(ctx, bson.M{}, opts).Decode(&last)
+		if err == nil {
+			rec.Seq = last.Seq + 1
+			rec.PrevHash = last.Hash
+		} else if !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+		rec.Hash = computeHash(rec)
+
+		if _, err := database.AuditCollection.InsertOne(ctx, rec); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			return err
+		}
+
+		if interval := config.AppConfig.AuditCheckpointInterval; interval > 0 && rec.Seq%interval == 0 {
+			checkpoint := Checkpoint{
+				Seq:       rec.Seq,
+				Hash:      rec.Hash,
+				Signature: sign(rec.Seq, rec.Hash),
+				CreatedAt: time.Now(),
+			}
+			if _, err := database.AuditCheckpointCollection.InsertOne(ctx, checkpoint); err != nil {
+				log.Printf("Failed to write audit checkpoint at record %d: %v", rec.Seq, err)
+			}
+		}
+		return nil
+	}
+	return errors.New("too many concurrent appends")
+}
+
+// Summary reports what Verify checked.
+type Summary struct {
+	Records     int64
+	Checkpoints int64
+}
+
+// Verify walks the whole chain and all checkpoints. It returns a
+// *BrokenLinkError pointing at the first record that is missing, modified or
+// out of place.
+func Verify(ctx context.Context) (Summary, error) {
+	var summary Summary
+
+	// Load the checkpoints first so they can be matched while streaming records.
+	checkpoints := map[int64]Checkpoint{}
+	cpCursor, err := database.AuditCheckpointCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return summary, err
+	}
+	var cps []Checkpoint
+	if err := cpCursor.All(ctx, &cps); err != nil {
+		return summary, err
+	}
+	var lastCheckpoint int64
+	for _, cp := range cps {
+		if !hmac.Equal([]byte(cp.Signature), []byte(sign(cp.Seq, cp.Hash))) {
+			return summary, &BrokenLinkError{Seq: cp.Seq, Reason: "checkpoint signature is invalid"}
+		}
+		checkpoints[cp.Seq] = cp
+		if cp.Seq > lastCheckpoint {
+			lastCheckpoint = cp.Seq
+		}
+	}
+	summary.Checkpoints = int64(len(cps))
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := database.AuditCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return summary, err
+	}
+	defer cursor.Close(ctx)
+
+	expectedSeq := int64(1)
+	prevHash := genesisHash
+	for cursor.Next(ctx) {
+		var rec Record
+		if err := cursor.Decode(&rec); err != nil {
+			return summary, err
+		}
+		if rec.Seq != expectedSeq {
+			return summary, &BrokenLinkError{Seq: expectedSeq, Reason: fmt.Sprintf("record is missing (next record is %d)", rec.Seq)}
+		}
+		if rec.PrevHash != prevHash {
+			return summary, &BrokenLinkError{Seq: rec.Seq, Reason: "previous hash does not match the preceding record"}
+		}
+		if computeHash(rec) != rec.Hash {
+			return summary, &BrokenLinkError{Seq: rec.Seq, Reason: "record contents do not match its hash"}
+		}
+		if cp, ok := checkpoints[rec.Seq]; ok && cp.Hash != rec.Hash {
+			return summary, &BrokenLinkError{Seq: rec.Seq, Reason: "record hash does not match its signed checkpoint"}
+		}
+
+		prevHash = rec.Hash
+		expectedSeq++
+		summary.Records++
+	}
+	if err := cursor.Err(); err != nil {
+		return summary, err
+	}
+
+	// A checkpoint beyond the end of the chain means records were truncated.
+	if lastCheckpoint >= expectedSeq {
+		return summary, &BrokenLinkError{Seq: expectedSeq, Reason: fmt.Sprintf("record is missing (signed checkpoint exists for record %d)", lastCheckpoint)}
+	}
+	return summary, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"file-hub-go/audit"
+	"file-hub-go/database"
+	"fmt"
+	"os"
+	"time"
+)
+
+const usage = `usage: filehub [command]
+
+Without a command the HTTP server is started.
+
+Commands:
+  audit verify    check the audit log hash chain and signed checkpoints`
+
+// runCommand executes a command-line subcommand and returns the process exit code.
+func runCommand(args []string) int {
+	switch {
+	case len(args) == 2 && args[0] == "audit" && args[1] == "verify":
+		return auditVerify()
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+}
+
+// auditVerify walks the audit chain and reports the first broken link, if any.
+func auditVerify() int {
+	database.InitMongoDB()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	summary, err := audit.Verify(ctx)
+	var broken *audit.BrokenLinkError
+	if errors.As(err, &broken) {
+		fmt.Printf("FAILED: %v\n", broken)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not verify audit log: %v\n", err)
+		return 2
+	}
+
+	fmt.Printf("OK: %d records and %d checkpoints verified\n", summary.Records, summary.Checkpoints)
+	return 0
+}
@@ -2,8 +2,11 @@ package config
 
 import (
 	"log"
+	"net"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -20,8 +23,39 @@ type Config struct {
 	DatabaseURL    string
 	JWTSecret      string
 	JWTExpiresIn   time.Duration
+	AdminUsers     []string
 	UploadDir      string
 	MaxUploadSize  int64
+	MaxZipSize     int64
+
+	MaxExtractedText int
+	MaxFileVersions  int
+
+	ArchiveMaxEntries int
+	ArchiveMaxRatio   int64
+
+	ImportMaxSize        int64
+	ImportTimeout        time.Duration
+	ImportMaxRedirects   int
+	ImportDeniedNetworks []*net.IPNet
+
+	DedupScope    string
+	InstantUpload string
+
+	ScanMode      string
+	ScanDownloads string
+	ClamdAddress  string
+	ScanTimeout   time.Duration
+	ScanInterval  time.Duration
+
+	TransformSizes     []int
+	TransformCacheSize int64
+
+	TrashRetention     time.Duration
+	TrashPurgeInterval time.Duration
+
+	AuditSigningKey         string
+	AuditCheckpointInterval int64
 }
 
 // LoadConfig loads configuration from a .env file and the environment.
@@ -37,9 +71,164 @@ func LoadConfig() {
 		DatabaseURL:    Getenv("DATABASE_URL", "postgres://my-psql-url"),
 		JWTSecret:      Getenv("JWT_SECRET", "a-very-secret-key-that-should-be-long-and-random"),
 		JWTExpiresIn:   getEnvAsDuration("JWT_EXPIRES_IN_HOURS", 24),
+		AdminUsers:     getEnvAsList("ADMIN_USERS"), // Usernames with access to instance-wide reports
 		UploadDir:      Getenv("UPLOAD_DIR", "uploads"),
 		MaxUploadSize:  getEnvAsInt64("MAX_UPLOAD_SIZE_MB", 10) * 1024 * 1024, // Convert MB to bytes
+		MaxZipSize:     getEnvAsInt64("MAX_ZIP_SIZE_MB", 1024) * 1024 * 1024,  // Convert MB to bytes
+
+		MaxExtractedText: int(getEnvAsInt64("MAX_EXTRACTED_TEXT_KB", 1024)) * 1024, // Convert KB to bytes
+		MaxFileVersions:  int(getEnvAsInt64("MAX_FILE_VERSIONS", 20)),              // 0 keeps every version
+
+		ArchiveMaxEntries: int(getEnvAsInt64("ARCHIVE_MAX_ENTRIES", 1000)),
+		ArchiveMaxRatio:   getEnvAsInt64("ARCHIVE_MAX_RATIO", 100), // Expanded size per byte of archive
+
+		ImportMaxSize:        getEnvAsInt64("IMPORT_MAX_SIZE_MB", 100) * 1024 * 1024, // Convert MB to bytes
+		ImportTimeout:        time.Duration(getEnvAsInt64("IMPORT_TIMEOUT_SECONDS", 120)) * time.Second,
+		ImportMaxRedirects:   int(getEnvAsInt64("IMPORT_MAX_REDIRECTS", 5)),
+		ImportDeniedNetworks: parseNetworks("IMPORT_DENIED_NETWORKS", defaultDeniedNetworks),
+
+		DedupScope:    parseChoice("DEDUP_SCOPE", DedupGlobal, DedupTenant, DedupUser, DedupOff),
+		InstantUpload: parseChoice("INSTANT_UPLOAD", InstantUploadAccessible, InstantUploadOff, InstantUploadAny),
+
+		ScanMode:      parseChoice("SCAN_MODE", ScanOff, ScanInline, ScanAsync),
+		ScanDownloads: parseChoice("SCAN_BLOCK_DOWNLOADS", ScanBlockInfected, ScanBlockUnscanned),
+		ClamdAddress:  Getenv("CLAMD_ADDRESS", "tcp://localhost:3310"),
+		ScanTimeout:   time.Duration(getEnvAsInt64("SCAN_TIMEOUT_SECONDS", 60)) * time.Second,
+		ScanInterval:  parseInterval("SCAN_INTERVAL_SECONDS", 60, time.Second), // Checks for pending scans and signature updates
+
+		TransformSizes:     parseSizes("IMAGE_TRANSFORM_SIZES", defaultTransformSizes),
+		TransformCacheSize: getEnvAsInt64("IMAGE_CACHE_MB", 512) * 1024 * 1024, // Convert MB to bytes
+
+		TrashRetention:     time.Duration(getEnvAsInt64("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour,
+		TrashPurgeInterval: parseInterval("TRASH_PURGE_INTERVAL_MINUTES", 60, time.Minute),
+
+		AuditSigningKey:         Getenv("AUDIT_SIGNING_KEY", ""),
+		AuditCheckpointInterval: getEnvAsInt64("AUDIT_CHECKPOINT_INTERVAL", 100),
+	}
+
+	// Fall back to the JWT secret so checkpoints are always signed with a server-only key.
+	if AppConfig.AuditSigningKey == "" {
+		AppConfig.AuditSigningKey = AppConfig.JWTSecret
+	}
+}
+
+// defaultDeniedNetworks are the address ranges URL imports may not connect
+// to: loopback, private, link-local (including cloud metadata endpoints),
+// shared and multicast ranges. On-premises installations that import from
+// internal servers can override the list with IMPORT_DENIED_NETWORKS.
+const defaultDeniedNetworks = "0.0.0.0/8,10.0.0.0/8,100.64.0.0/10,127.0.0.0/8,169.254.0.0/16,172.16.0.0/12,192.0.0.0/24,192.168.0.0/16,198.18.0.0/15,224.0.0.0/4,240.0.0.0/4,::/128,::1/128,fc00::/7,fe80::/10,ff00::/8"
+
+// defaultTransformSizes are the widths and heights images can be
+// transformed to. Keeping the list short bounds the number of variants that
+// can be generated of each image.
+const defaultTransformSizes = "32,64,128,256,320,480,640,800,1024,1280,1600,1920,2048"
+
+// Deduplication scopes: which stored content an upload may share a
+// physical file with.
+const (
+	DedupGlobal = "global"
+	// DedupTenant shares content within a workspace only.
+	DedupTenant = "tenant"
+	// DedupUser shares content between a user's own uploads only.
+	DedupUser = "user"
+	DedupOff  = "off"
+)
+
+// Policies for instant uploads, which create a file from content the server
+// already stores without transferring it again.
+const (
+	// InstantUploadAccessible only reuses content the caller can already
+	// read, so it tells them nothing about other users' files.
+	InstantUploadAccessible = "accessible"
+	InstantUploadOff        = "off"
+	// InstantUploadAny reuses any stored content. It reveals whether
+	// content exists anywhere and suits single-tenant installations only.
+	InstantUploadAny = "any"
+)
+
+// Virus scanning modes.
+const (
+	ScanOff = "off"
+	// ScanInline scans uploads before they are stored and rejects infected
+	// ones.
+	ScanInline = "inline"
+	// ScanAsync stores uploads at once and quarantines them until a
+	// background scan has found them clean.
+	ScanAsync = "async"
+)
+
+// Which files downloads are blocked for, besides quarantined ones.
+const (
+	ScanBlockInfected = "infected"
+	// ScanBlockUnscanned only serves files that were scanned and found
+	// clean.
+	ScanBlockUnscanned = "unscanned"
+)
+
+// parseChoice reads a setting that must be one of a fixed set of values.
+// The first value is the default.
+func parseChoice(key string, values ...string) string {
+	value := Getenv(key, values[0])
+	if !slices.Contains(values, value) {
+		log.Fatalf("Invalid %s %q, must be one of %s", key, value, strings.Join(values, ", "))
+	}
+	return value
+}
+
+// parseNetworks reads a comma-separated list of CIDR ranges. An invalid
+// entry is fatal so that a typo cannot silently open up the list.
+func parseNetworks(key, fallback string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(Getenv(key, fallback), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatalf("Invalid network %q in %s: %v", cidr, key, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// parseSizes reads a comma-separated list of pixel sizes. An invalid entry
+// is fatal.
+func parseSizes(key, fallback string) []int {
+	var sizes []int
+	for _, value := range strings.Split(Getenv(key, fallback), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 || size > 8192 {
+			log.Fatalf("Invalid size %q in %s, must be between 1 and 8192", value, key)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes
+}
+
+// parseInterval reads how often a background task runs, as a number of
+// units. Intervals below one unit are fatal since a ticker cannot run on
+// them.
+func parseInterval(key string, fallback int64, unit time.Duration) time.Duration {
+	value := getEnvAsInt64(key, fallback)
+	if value < 1 {
+		log.Fatalf("Invalid %s %d, must be at least 1", key, value)
+	}
+	return time.Duration(value) * unit
+}
+
+// getEnvAsList reads a comma-separated list, skipping empty entries.
+func getEnvAsList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(Getenv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
 	}
+	return values
 }
 
 // Getenv retrieves an environment variable or returns a fallback.
@@ -2,11 +2,13 @@ package database
 
 import (
 	"context"
+	"errors"
 	"file-hub-go/config"
 	"log"
 	"os"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -18,6 +20,29 @@ var DB *mongo.Client
 // We'll use this to perform operations on our file documents.
 var FileCollection *mongo.Collection
 
+// FolderCollection is a handle to the "folders" collection.
+var FolderCollection *mongo.Collection
+
+// WorkspaceCollection is a handle to the "workspaces" collection.
+var WorkspaceCollection *mongo.Collection
+
+// MetadataSchemaCollection holds one custom metadata schema per workspace.
+var MetadataSchemaCollection *mongo.Collection
+
+// UploadPolicyCollection holds the global upload policy and one per workspace.
+var UploadPolicyCollection *mongo.Collection
+
+// JobCollection tracks background jobs such as large batch operations.
+var JobCollection *mongo.Collection
+
+// ArchiveListingCollection caches the contents of stored archives by hash.
+var ArchiveListingCollection *mongo.Collection
+
+// AuditCollection holds the hash-chained audit records and
+// AuditCheckpointCollection the signed checkpoints over that chain.
+var AuditCollection *mongo.Collection
+var AuditCheckpointCollection *mongo.Collection
+
 func InitMongoDB() {
 	// Ensure the uploads directory exists for storing physical files.
 	if err := os.MkdirAll(config.AppConfig.UploadDir, 0755); err != nil {
@@ -50,4 +75,152 @@ func InitMongoDB() {
 
 	// Get a handle for your "files" collection within the "filehub" database.
 	FileCollection = client.Database("filehub").Collection("files")
+	AuditCollection = client.Database("filehub").Collection("audit")
+	AuditCheckpointCollection = client.Database("filehub").Collection("audit_checkpoints")
+	FolderCollection = client.Database("filehub").Collection("folders")
+	WorkspaceCollection = client.Database("filehub").Collection("workspaces")
+	MetadataSchemaCollection = client.Database("filehub").Collection("metadata_schemas")
+	UploadPolicyCollection = client.Database("filehub").Collection("upload_policies")
+	JobCollection = client.Database("filehub").Collection("jobs")
+	ArchiveListingCollection = client.Database("filehub").Collection("archive_listings")
+
+	detectTransactions(ctx)
+
+	if err := ensureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+}
+
+// MongoDB error codes for dropping an index that is not there: the index
+// does not exist, or the whole collection does not exist yet.
+const (
+	errCodeNamespaceNotFound = 26
+	errCodeIndexNotFound     = 27
+)
+
+// indexMissing reports whether err means that the index to drop did not
+// exist, so there was nothing to do.
+func indexMissing(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) &&
+		(serverErr.HasErrorCode(errCodeIndexNotFound) || serverErr.HasErrorCode(errCodeNamespaceNotFound))
+}
+
+// ensureIndexes creates the indexes the application relies on. CreateMany is
+// a no-op for indexes that already exist.
+func ensureIndexes(ctx context.Context) error {
+	// Folder names must be unique among their siblings. Root folders of
+	// different workspaces are not siblings, so the workspace is part of the
+	// key; drop the earlier index that did not include it.
+	if _, err := FolderCollection.Indexes().DropOne(ctx, "parent_id_1_name_1"); err != nil && !indexMissing(err) {
+		return err
+	}
+	_, err := FolderCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "workspace_id", Value: 1}, {Key: "parent_id", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = FileCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "folder_id", Value: 1}, {Key: "uploaded_at", Value: -1}}},
+		// Keyset pagination sorts by the sort field with _id as tie-breaker.
+		{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "uploaded_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "size", Value: -1}, {Key: "_id", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "workspace_id", Value: 1}, {Key: "original_filename", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+		},
+		{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "folder_id", Value: 1}, {Key: "original_filename", Value: 1}}},
+		// Multikey indexes for tag filters and the per-user tag listing.
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "owner", Value: 1}, {Key: "tags", Value: 1}}},
+		// Deduplication looks up stored content by hash, including the
+		// content of earlier versions.
+		{Keys: bson.D{{Key: "hash", Value: 1}}},
+		{Keys: bson.D{{Key: "versions.hash", Value: 1}}},
+		// Physical files are released once no file or version points at them.
+		{Keys: bson.D{{Key: "file", Value: 1}}},
+		{Keys: bson.D{{Key: "versions.file", Value: 1}}},
+		// The trash listing and the purger look files up by deletion time.
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+		// The virus scanner picks up quarantined uploads.
+		{Keys: bson.D{{Key: "scan.status", Value: 1}}},
+		{Keys: bson.D{{Key: "versions.scan.status", Value: 1}}},
+		// Full-text index over extracted document text. Stemming is turned
+		// off so that matches can be highlighted literally.
+		{
+			Keys:    bson.D{{Key: "content", Value: "text"}},
+			Options: options.Index().SetDefaultLanguage("none"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = WorkspaceCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "members.username", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Finished and abandoned jobs are removed a week after they were created.
+	_, err = JobCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(7 * 24 * 60 * 60),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Archive listings are cheap to rebuild, so they expire a month after
+	// they were made rather than tracking which blobs still exist.
+	_, err = ArchiveListingCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60),
+		},
+	})
+	return err
+}
+
+// supportsTransactions is set when the deployment is a replica set or a
+// sharded cluster; standalone servers cannot run transactions.
+var supportsTransactions bool
+
+// detectTransactions records whether the server supports transactions.
+func detectTransactions(ctx context.Context) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := DB.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	supportsTransactions = err == nil && (hello.SetName != "" || hello.Msg == "isdbgrid")
+	if !supportsTransactions {
+		log.Println("MongoDB does not support transactions; batch operations will run without them")
+	}
+}
+
+// WithTransaction runs fn in a transaction if the deployment supports them,
+// and directly otherwise. fn must do all its work through the context it is
+// given.
+func WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !supportsTransactions {
+		return fn(ctx)
+	}
+	session, err := DB.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
 }
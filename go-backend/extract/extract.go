@@ -0,0 +1,131 @@
+// Package extract pulls plain text out of uploaded documents so that they can
+// be searched by content. Only pure-Go parsing is used; formats that cannot be
+// handled yield no text rather than an error.
+package extract
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrUnsupported is returned for files whose format has no text extractor.
+var ErrUnsupported = errors.New("no text extractor for this file type")
+
+// maxPartSize bounds how much is read or decompressed from any single part of
+// a document, which protects against compression bombs.
+const maxPartSize = 32 << 20
+
+type extractor func(r io.ReaderAt, size int64) (string, error)
+
+// extractors by file extension.
+var byExtension = map[string]extractor{
+	".txt":      plainText,
+	".text":     plainText,
+	".log":      plainText,
+	".md":       plainText,
+	".markdown": plainText,
+	".csv":      plainText,
+	".tsv":      plainText,
+	".json":     plainText,
+	".html":     htmlText,
+	".htm":      htmlText,
+	".pdf":      pdfText,
+	".docx":     docxText,
+	".xlsx":     xlsxText,
+	".pptx":     pptxText,
+}
+
+// extractors by MIME type, used when the extension is not recognised.
+var byMIMEType = map[string]extractor{
+	"text/plain":       plainText,
+	"text/markdown":    plainText,
+	"text/csv":         plainText,
+	"application/json": plainText,
+	"text/html":        htmlText,
+	"application/pdf":  pdfText,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   docxText,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         xlsxText,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": pptxText,
+}
+
+// Supported reports whether Text can handle a file with this name and type.
+func Supported(filename, contentType string) bool {
+	return pick(filename, contentType) != nil
+}
+
+func pick(filename, contentType string) extractor {
+	if fn, ok := byExtension[strings.ToLower(filepath.Ext(filename))]; ok {
+		return fn
+	}
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	return byMIMEType[mediaType]
+}
+
+// Text extracts the text of a document. The result has its whitespace
+// collapsed and is cut to at most maxBytes bytes.
+func Text(r io.ReaderAt, size int64, filename, contentType string, maxBytes int) (string, error) {
+	fn := pick(filename, contentType)
+	if fn == nil {
+		return "", ErrUnsupported
+	}
+	text, err := fn(r, size)
+	if err != nil {
+		return "", err
+	}
+	return normalize(text, maxBytes), nil
+}
+
+// normalize replaces invalid UTF-8 and control characters, collapses runs of
+// whitespace and truncates the text on a rune boundary.
+func normalize(text string, maxBytes int) string {
+	var b strings.Builder
+	space := false
+	for _, c := range strings.ToValidUTF8(text, " ") {
+		if unicode.IsSpace(c) || unicode.IsControl(c) {
+			space = b.Len() > 0
+			continue
+		}
+		if space {
+			if b.Len()+1 > maxBytes {
+				break
+			}
+			b.WriteByte(' ')
+			space = false
+		}
+		if b.Len()+utf8.RuneLen(c) > maxBytes {
+			break
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// readAll reads the whole document, up to maxPartSize bytes.
+func readAll(r io.ReaderAt, size int64) ([]byte, error) {
+	if size > maxPartSize {
+		size = maxPartSize
+	}
+	return io.ReadAll(io.NewSectionReader(r, 0, size))
+}
+
+// plainText handles text formats whose bytes already are the text. Files that
+// look binary yield no text.
+func plainText(r io.ReaderAt, size int64) (string, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return "", err
+	}
+	head := data
+	if len(head) > 8192 {
+		head = head[:8192]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return "", nil
+	}
+	return string(data), nil
+}
@@ -0,0 +1,105 @@
+package extract
+
+import (
+	"html"
+	"io"
+	"strings"
+)
+
+// htmlBlockTags end a line of text when they open or close.
+var htmlBlockTags = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "td": true, "th": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"section": true, "article": true, "header": true, "footer": true, "title": true,
+}
+
+// htmlText strips the markup from an HTML document. The contents of script
+// and style elements and of comments are dropped.
+func htmlText(r io.ReaderAt, size int64) (string, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return "", err
+	}
+	doc := string(data)
+
+	var b strings.Builder
+	for len(doc) > 0 {
+		lt := strings.IndexByte(doc, '<')
+		if lt < 0 {
+			b.WriteString(html.UnescapeString(doc))
+			break
+		}
+		b.WriteString(html.UnescapeString(doc[:lt]))
+		doc = doc[lt:]
+
+		if strings.HasPrefix(doc, "<!--") {
+			end := strings.Index(doc, "-->")
+			if end < 0 {
+				break
+			}
+			doc = doc[end+3:]
+			continue
+		}
+
+		gt := strings.IndexByte(doc, '>')
+		if gt < 0 {
+			break
+		}
+		name := tagName(doc[1:gt])
+		doc = doc[gt+1:]
+
+		if name == "script" || name == "style" {
+			end := indexFold(doc, "</"+name)
+			if end < 0 {
+				break
+			}
+			doc = doc[end:]
+			continue
+		}
+		if htmlBlockTags[strings.TrimPrefix(name, "/")] {
+			b.WriteByte('\n')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String(), nil
+}
+
+// tagName returns the lower-cased name of a tag from the text between < and >,
+// keeping a leading '/' for closing tags.
+func tagName(tag string) string {
+	end := strings.IndexAny(tag, " \t\r\n/>")
+	if strings.HasPrefix(tag, "/") {
+		end = strings.IndexAny(tag[1:], " \t\r\n>")
+		if end >= 0 {
+			end++
+		}
+	}
+	if end < 0 {
+		end = len(tag)
+	}
+	return strings.ToLower(tag[:end])
+}
+
+// indexFold returns the index of the first instance of the lower-case ASCII
+// substr in s, ignoring ASCII case, or -1. Unlike searching strings.ToLower(s)
+// the index is into s itself, whatever bytes s holds.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		match := true
+		for j := 0; j < len(substr); j++ {
+			c := s[i+j]
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			if c != substr[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
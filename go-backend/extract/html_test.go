@@ -0,0 +1,33 @@
+package extract
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHTMLTextDropsScriptsWithInvalidUTF8(t *testing.T) {
+	// strings.ToLower turns each invalid byte into a three-byte U+FFFD, so
+	// indexes into the lower-cased text do not fit the original.
+	doc := "<p>before</p><SCRIPT>" + strings.Repeat("\xff", 100) + "</Script><p>after</p>"
+	text, err := htmlText(strings.NewReader(doc), int64(len(doc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "before") || !strings.Contains(text, "after") {
+		t.Errorf("text = %q, want both paragraphs", text)
+	}
+	if strings.Contains(text, "\xff") {
+		t.Errorf("text = %q, want the script dropped", text)
+	}
+}
+
+func TestHTMLTextUnterminatedScript(t *testing.T) {
+	doc := "<p>before</p><style>\xc3" + strings.Repeat("\xff", 10)
+	text, err := htmlText(strings.NewReader(doc), int64(len(doc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(text) != "before" {
+		t.Errorf("text = %q, want %q", text, "before")
+	}
+}
@@ -0,0 +1,111 @@
+package extract
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Office Open XML documents (docx, xlsx, pptx) are ZIP archives of XML parts.
+// The visible text lives in elements with the local name "t"; paragraphs and
+// shared-string items mark line breaks.
+
+func docxText(r io.ReaderAt, size int64) (string, error) {
+	return ooxmlText(r, size, func(name string) bool {
+		return name == "word/document.xml" ||
+			strings.HasPrefix(name, "word/header") ||
+			strings.HasPrefix(name, "word/footer") ||
+			name == "word/footnotes.xml"
+	})
+}
+
+func xlsxText(r io.ReaderAt, size int64) (string, error) {
+	return ooxmlText(r, size, func(name string) bool {
+		return name == "xl/sharedStrings.xml" || strings.HasPrefix(name, "xl/worksheets/sheet")
+	})
+}
+
+func pptxText(r io.ReaderAt, size int64) (string, error) {
+	return ooxmlText(r, size, func(name string) bool {
+		return strings.HasPrefix(name, "ppt/slides/slide") || strings.HasPrefix(name, "ppt/notesSlides/notesSlide")
+	})
+}
+
+// ooxmlText concatenates the text of every XML part selected by want, in
+// natural order so that slide10 follows slide9.
+func ooxmlText(r io.ReaderAt, size int64, want func(name string) bool) (string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", err
+	}
+
+	var parts []*zip.File
+	for _, f := range zr.File {
+		if path.Ext(f.Name) == ".xml" && want(f.Name) {
+			parts = append(parts, f)
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		a, b := parts[i].Name, parts[j].Name
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+
+	var b strings.Builder
+	for _, part := range parts {
+		rc, err := part.Open()
+		if err != nil {
+			return "", err
+		}
+		err = xmlText(io.LimitReader(rc, maxPartSize), &b)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// xmlText writes the character data of all "t" elements to b, with a line
+// break after every paragraph, table cell or shared string.
+func xmlText(r io.Reader, b *strings.Builder) error {
+	dec := xml.NewDecoder(r)
+	inText := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText++
+			case "tab":
+				b.WriteByte('\t')
+			case "br":
+				b.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText--
+			case "p", "si", "c":
+				b.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText > 0 {
+				b.Write(t)
+			}
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// PDF text extraction reads the text layer of a PDF: it inflates the content
+// streams and collects the strings shown by the Tj, TJ, ' and " operators.
+// It does not interpret font encodings, so text set in fonts with custom
+// encodings (common for CID fonts) may come out as noise or not at all.
+// Scanned PDFs without a text layer yield no text.
+
+var (
+	pdfStreamStart = regexp.MustCompile(`stream\r?\n`)
+	pdfObjStart    = regexp.MustCompile(`\d+\s+\d+\s+obj\b`)
+)
+
+func pdfText(r io.ReaderAt, size int64) (string, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return "", nil
+	}
+
+	var b strings.Builder
+	objs := pdfObjStart.FindAllIndex(data, -1)
+	next := 0 // The first object that starts after the current stream
+	for _, loc := range pdfStreamStart.FindAllIndex(data, -1) {
+		// The stream dictionary sits between the object header and "stream".
+		for next < len(objs) && objs[next][1] <= loc[0] {
+			next++
+		}
+		dictStart := 0
+		if next > 0 {
+			dictStart = objs[next-1][1]
+		}
+		dict := data[dictStart:loc[0]]
+
+		end := bytes.Index(data[loc[1]:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		raw := data[loc[1] : loc[1]+end]
+
+		content, ok := pdfDecodeStream(dict, raw)
+		if !ok || !bytes.Contains(content, []byte("BT")) {
+			continue
+		}
+		pdfContentText(content, &b)
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// pdfDecodeStream returns the decoded bytes of an unfiltered or
+// FlateDecode-filtered stream. Other filters (images, fonts) are skipped.
+func pdfDecodeStream(dict, raw []byte) ([]byte, bool) {
+	if bytes.Contains(dict, []byte("/Subtype/Image")) || bytes.Contains(dict, []byte("/Subtype /Image")) {
+		return nil, false
+	}
+	if !bytes.Contains(dict, []byte("/Filter")) {
+		return raw, true
+	}
+	if !bytes.Contains(dict, []byte("/FlateDecode")) || bytes.Contains(dict, []byte("/DecodeParms")) {
+		return nil, false
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, false
+	}
+	defer zr.Close()
+	content, err := io.ReadAll(io.LimitReader(zr, maxPartSize))
+	if err != nil && len(content) == 0 {
+		return nil, false
+	}
+	return content, true
+}
+
+// pdfContentText interprets the text operators of a content stream.
+func pdfContentText(content []byte, b *strings.Builder) {
+	var operands []string // strings among the operands of the next operator
+	var array []string    // strings inside the current [...] array
+	inArray := false
+	inText := false
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, next := pdfLiteralString(content, i)
+			if inArray {
+				array = append(array, s)
+			} else {
+				operands = append(operands, s)
+			}
+			i = next
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+			s := pdfHexString(content[i+1 : i+end])
+			if inArray {
+				array = append(array, s)
+			} else {
+				operands = append(operands, s)
+			}
+			i += end + 1
+		case c == '[':
+			inArray, array = true, nil
+			i++
+		case c == ']':
+			inArray = false
+			operands = append(operands, strings.Join(array, ""))
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case inArray && (c == '-' || c >= '0' && c <= '9'):
+			// Large negative kerning in a TJ array is how PDFs write spaces.
+			start := i
+			for i < len(content) && (content[i] == '-' || content[i] == '.' || content[i] >= '0' && content[i] <= '9') {
+				i++
+			}
+			if n, err := strconv.ParseFloat(string(content[start:i]), 64); err == nil && n < -200 {
+				array = append(array, " ")
+			}
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '\'' || c == '"' || c == '*':
+			start := i
+			for i < len(content) && (content[i] >= 'A' && content[i] <= 'Z' || content[i] >= 'a' && content[i] <= 'z' || content[i] == '\'' || content[i] == '"' || content[i] == '*') {
+				i++
+			}
+			if inArray {
+				continue
+			}
+			switch string(content[start:i]) {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				b.WriteByte('\n')
+			case "Tj", "TJ":
+				if inText {
+					b.WriteString(strings.Join(operands, ""))
+				}
+			case "'", "\"":
+				if inText {
+					b.WriteByte('\n')
+					b.WriteString(strings.Join(operands, ""))
+				}
+			case "Td", "TD", "T*", "Tm":
+				if inText {
+					b.WriteByte(' ')
+				}
+			}
+			operands = operands[:0]
+		default:
+			i++
+		}
+	}
+}
+
+// pdfLiteralString decodes a (...) string starting at content[start] and
+// returns it with the index just after the closing parenthesis.
+func pdfLiteralString(content []byte, start int) (string, int) {
+	var out []byte
+	depth := 0
+	i := start
+	for ; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '\\' && i+1 < len(content):
+			i++
+			switch e := content[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					n := 0
+					for j := 0; j < 3 && i < len(content) && content[i] >= '0' && content[i] <= '7'; j++ {
+						n = n*8 + int(content[i]-'0')
+						i++
+					}
+					i--
+					out = append(out, byte(n))
+				} else {
+					out = append(out, e)
+				}
+			}
+		case c == '(':
+			if depth > 0 {
+				out = append(out, c)
+			}
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return pdfDecodeText(out), i + 1
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return pdfDecodeText(out), i
+}
+
+// pdfHexString decodes a <...> hex string.
+func pdfHexString(hex []byte) string {
+	var digits []byte
+	for _, c := range hex {
+		if c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F' {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		n, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		out[i] = byte(n)
+	}
+	return pdfDecodeText(out)
+}
+
+// pdfDecodeText decodes UTF-16BE strings (marked with a byte order mark) and
+// treats everything else as Latin-1. Unprintable bytes are dropped.
+func pdfDecodeText(s []byte) string {
+	if len(s) >= 2 && s[0] == 0xFE && s[1] == 0xFF {
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, 0, len(s))
+	for _, c := range s {
+		if c >= 0x20 && c != 0x7f || c == '\n' || c == '\t' {
+			runes = append(runes, rune(c))
+		}
+	}
+	return string(runes)
+}
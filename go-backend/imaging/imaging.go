@@ -0,0 +1,363 @@
+// Package imaging decodes, resizes and encodes images for previews. Only the
+// standard library's JPEG, PNG and GIF codecs are used, so no image library
+// or external tool is needed.
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+)
+
+// MaxPixels bounds the size of images that are decoded, which protects
+// against images that are small on disk but huge in memory.
+const MaxPixels = 30_000_000
+
+var (
+	// ErrUnsupported is returned for content that is not a JPEG, PNG or GIF.
+	ErrUnsupported = errors.New("not a JPEG, PNG or GIF image")
+	// ErrTooLarge is returned for images with more than MaxPixels pixels.
+	ErrTooLarge = errors.New("image has too many pixels")
+)
+
+// Supported reports whether images of a MIME type can be decoded.
+func Supported(fileType string) bool {
+	switch fileType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Source is a decoded image. Photos are often stored sideways with an EXIF
+// orientation that tells viewers how to turn them; it is applied when the
+// image is resized.
+type Source struct {
+	Image       image.Image
+	Format      string // "jpeg", "png" or "gif"
+	Orientation int    // EXIF orientation, 1 for upright
+}
+
+// Decode reads an image. GIFs yield their first frame.
+func Decode(r io.ReadSeeker) (Source, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return Source{}, ErrUnsupported
+	}
+	if format != "jpeg" && format != "png" && format != "gif" {
+		return Source{}, ErrUnsupported
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return Source{}, ErrTooLarge
+	}
+
+	src := Source{Format: format, Orientation: 1}
+	if format == "jpeg" {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return Source{}, err
+		}
+		src.Orientation = jpegOrientation(r)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return Source{}, err
+	}
+	switch format {
+	case "jpeg":
+		src.Image, err = jpeg.Decode(r)
+	case "png":
+		src.Image, err = png.Decode(r)
+	case "gif":
+		src.Image, err = gif.Decode(r)
+	}
+	if err != nil {
+		// The header was fine but the image data is broken.
+		return Source{}, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	return src, nil
+}
+
+// Size returns the width and height of the image as it is displayed.
+func (s Source) Size() (int, int) {
+	b := s.Image.Bounds()
+	if s.swapsAxes() {
+		return b.Dy(), b.Dx()
+	}
+	return b.Dx(), b.Dy()
+}
+
+// swapsAxes reports whether the orientation turns the image by 90 degrees.
+func (s Source) swapsAxes() bool {
+	return s.Orientation >= 5 && s.Orientation <= 8
+}
+
+// Resize scales the image to width×height as displayed, turning it upright.
+// The aspect ratio is not kept; see FitSize.
+func (s Source) Resize(width, height int) *image.RGBA {
+	if s.swapsAxes() {
+		return orient(resample(s.Image, height, width), s.Orientation)
+	}
+	return orient(resample(s.Image, width, height), s.Orientation)
+}
+
+// FitSize returns the largest size with the aspect ratio of w×h that fits
+// in maxWidth×maxHeight, without enlarging. A zero bound is unconstrained.
+func FitSize(w, h, maxWidth, maxHeight int) (int, int) {
+	scale := 1.0
+	if maxWidth > 0 {
+		scale = math.Min(scale, float64(maxWidth)/float64(w))
+	}
+	if maxHeight > 0 {
+		scale = math.Min(scale, float64(maxHeight)/float64(h))
+	}
+	return max(1, int(math.Round(float64(w)*scale))), max(1, int(math.Round(float64(h)*scale)))
+}
+
+// Cover scales the image to cover width×height, keeping its aspect ratio,
+// and crops what sticks out equally from both sides.
+func (s Source) Cover(width, height int) *image.RGBA {
+	w, h := s.Size()
+	scale := math.Max(float64(width)/float64(w), float64(height)/float64(h))
+	scaled := s.Resize(max(width, int(math.Ceil(float64(w)*scale))), max(height, int(math.Ceil(float64(h)*scale))))
+	b := scaled.Bounds()
+	x, y := (b.Dx()-width)/2, (b.Dy()-height)/2
+	cropped := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(cropped, cropped.Bounds(), scaled, image.Pt(x, y), draw.Src)
+	return cropped
+}
+
+// Thumbnail scales the image to fit in a size×size box, turning it upright.
+// Images that already fit are only turned.
+func (s Source) Thumbnail(size int) *image.RGBA {
+	w, h := s.Size()
+	return s.Resize(FitSize(w, h, size, size))
+}
+
+// Encode writes img as a JPEG or PNG. JPEG has no transparency, so
+// transparent areas are put on a white background.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	if format == "png" {
+		enc := png.Encoder{CompressionLevel: png.BestSpeed}
+		return enc.Encode(w, img)
+	}
+	b := img.Bounds()
+	flat := image.NewRGBA(b)
+	draw.Draw(flat, b, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, b, img, b.Min, draw.Over)
+	return jpeg.Encode(w, flat, &jpeg.Options{Quality: quality})
+}
+
+// weight is the contribution of a run of source pixels to one destination
+// pixel.
+type weight struct {
+	start  int
+	values []float32
+}
+
+// weights computes a triangle (linear) filter from srcSize to dstSize
+// pixels. When shrinking, the filter is widened to cover every source
+// pixel, which averages them rather than skipping some.
+func weights(dstSize, srcSize int) []weight {
+	scale := float64(srcSize) / float64(dstSize)
+	support := math.Max(1, scale)
+	out := make([]weight, dstSize)
+	for i := range out {
+		center := (float64(i)+0.5)*scale - 0.5
+		start := max(0, int(math.Ceil(center-support)))
+		end := min(srcSize-1, int(math.Floor(center+support)))
+		var sum float64
+		values := make([]float32, 0, end-start+1)
+		for j := start; j <= end; j++ {
+			v := math.Max(0, 1-math.Abs(float64(j)-center)/support)
+			values = append(values, float32(v))
+			sum += v
+		}
+		if sum == 0 {
+			// Only possible at the very edges; take the nearest pixel.
+			start = min(max(0, int(math.Round(center))), srcSize-1)
+			values, sum = []float32{1}, 1
+		}
+		for k := range values {
+			values[k] /= float32(sum)
+		}
+		out[i] = weight{start: start, values: values}
+	}
+	return out
+}
+
+// resample scales src to width×height in two passes, first across then
+// down. Colours are blended premultiplied so transparent pixels do not
+// darken their neighbours.
+func resample(src image.Image, width, height int) *image.RGBA {
+	b := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	} else if b.Min != (image.Point{}) {
+		rgba = rgba.SubImage(b).(*image.RGBA)
+	}
+	srcW, srcH := b.Dx(), b.Dy()
+
+	// Horizontal pass into a float buffer of srcH rows of width pixels.
+	across := weights(width, srcW)
+	tmp := make([]float32, srcH*width*4)
+	for y := 0; y < srcH; y++ {
+		row := rgba.Pix[y*rgba.Stride:]
+		for x, w := range across {
+			var r, g, bl, a float32
+			for k, v := range w.values {
+				p := row[(w.start+k)*4:]
+				r += float32(p[0]) * v
+				g += float32(p[1]) * v
+				bl += float32(p[2]) * v
+				a += float32(p[3]) * v
+			}
+			o := (y*width + x) * 4
+			tmp[o], tmp[o+1], tmp[o+2], tmp[o+3] = r, g, bl, a
+		}
+	}
+
+	// Vertical pass into the result.
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	down := weights(height, srcH)
+	for y, w := range down {
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < width; x++ {
+			var r, g, bl, a float32
+			for k, v := range w.values {
+				o := ((w.start+k)*width + x) * 4
+				r += tmp[o] * v
+				g += tmp[o+1] * v
+				bl += tmp[o+2] * v
+				a += tmp[o+3] * v
+			}
+			alpha := clamp(a)
+			// Premultiplied colour never exceeds alpha.
+			out[x*4] = min(clamp(r), alpha)
+			out[x*4+1] = min(clamp(g), alpha)
+			out[x*4+2] = min(clamp(bl), alpha)
+			out[x*4+3] = alpha
+		}
+	}
+	return dst
+}
+
+func clamp(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// orient turns an image stored with an EXIF orientation upright.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored
+				dx, dy = w-1-x, y
+			case 3: // Upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // Upside down and mirrored
+				dx, dy = x, h-1-y
+			case 5: // Transposed
+				dx, dy = y, x
+			case 6: // Turned left, so turn right
+				dx, dy = h-1-y, x
+			case 7: // Transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // Turned right, so turn left
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dy*dst.Stride+dx*4:dy*dst.Stride+dx*4+4], src.Pix[y*src.Stride+x*4:])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation of a JPEG, or 1 if it has
+// none. Only the segments before the image data are read.
+func jpegOrientation(r io.Reader) int {
+	header := make([]byte, 64*1024)
+	n, _ := io.ReadFull(r, header)
+	header = header[:n]
+	if len(header) < 4 || header[0] != 0xFF || header[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(header); {
+		if header[i] != 0xFF {
+			return 1
+		}
+		marker := header[i+1]
+		if marker == 0xDA || marker == 0xD9 { // Start of scan, end of image
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(header[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(header) {
+			return 1
+		}
+		if marker == 0xE1 && bytes.HasPrefix(header[i+4:end], []byte("Exif\x00\x00")) {
+			return exifOrientation(header[i+10 : end])
+		}
+		i = end
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of a TIFF
+// structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < count; k++ {
+		entry := ifd + 2 + k*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if value := int(order.Uint16(tiff[entry+8:])); value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
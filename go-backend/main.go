@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 
 	"file-hub-go/api"
 	"file-hub-go/config"
@@ -18,18 +19,33 @@ func main() {
 	// Load environment variables from .env file
 	config.LoadConfig()
 
+	// Subcommands such as `filehub audit verify` run instead of the server.
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// Initialize database connections
 	database.InitMongoDB()
 	database.InitUserDB()
 
+	// Hard-delete files that have been in the trash past the retention period
+	api.StartTrashPurger()
+
+	// Scan uploads for viruses in the background and after signature updates
+	api.StartVirusScanner()
+
+	// Generate previews of uploaded images
+	api.StartThumbnailer()
+	api.InitTransformCache()
+
 	r := chi.NewRouter()
 
 	// CORS configuration
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{config.AppConfig.AllowedOrigins},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "Location"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any browser
 	})
@@ -52,12 +68,63 @@ func main() {
 		// File related routes
 		r.Get("/api/files/", api.GetFiles)
 		r.Post("/api/files/", api.UploadFile)
+		r.Get("/api/files/facets/", api.GetFileFacets)
+		r.Post("/api/files/batch/", api.BatchFiles)
+		r.Get("/api/files/zip/", api.DownloadZip)
+		r.Post("/api/files/precheck/", api.PrecheckUpload)
+		r.Post("/api/files/import/", api.ImportFile)
+		r.Get("/api/files/duplicates/", api.GetDuplicates)
+		r.Post("/api/files/duplicates/{hash}/collapse/", api.CollapseDuplicates)
+		r.Get("/api/files/{id}/", api.GetFile)
+		r.Get("/api/files/{id}/download/", api.DownloadFile)
+		r.Get("/api/files/{id}/thumbnail/", api.GetThumbnail)
+		r.Get("/api/files/{id}/transform/", api.TransformImage)
+		r.Patch("/api/files/{id}/", api.UpdateFile)
 		r.Delete("/api/files/{id}/", api.DeleteFile)
-	})
+		r.Post("/api/files/{id}/copy/", api.CopyFile)
+		r.Post("/api/files/{id}/move/", api.MoveFile)
+		r.Get("/api/files/{id}/archive/", api.ListArchive)
+		r.Get("/api/files/{id}/archive/*", api.GetArchiveEntry)
+		r.Get("/api/files/{id}/versions/", api.GetFileVersions)
+		r.Post("/api/files/{id}/versions/", api.UploadFileVersion)
+		r.Get("/api/files/{id}/versions/{version}/", api.DownloadFileVersion)
+		r.Post("/api/files/{id}/versions/{version}/restore/", api.RestoreFileVersion)
+		r.Post("/api/files/{id}/tags/", api.AddFileTags)
+		r.Delete("/api/files/{id}/tags/{tag}/", api.RemoveFileTag)
+
+		// Trash related routes
+		r.Get("/api/trash/", api.GetTrash)
+		r.Delete("/api/trash/", api.EmptyTrash)
+		r.Post("/api/trash/{id}/restore/", api.RestoreFile)
+		r.Delete("/api/trash/{id}/", api.PurgeFile)
 
-	// Serve static files from the 'uploads' directory
-	fs := http.FileServer(http.Dir(config.AppConfig.UploadDir))
-	r.Handle("/uploads/*", http.StripPrefix("/uploads/", fs))
+		// Background jobs
+		r.Get("/api/jobs/{id}/", api.GetJob)
+
+		// Tag related routes
+		r.Get("/api/tags/", api.GetTags)
+		r.Post("/api/tags/bulk/", api.BulkTagFiles)
+
+		// Folder related routes
+		r.Post("/api/folders/", api.CreateFolder)
+		r.Patch("/api/folders/{id}/", api.RenameFolder)
+		r.Post("/api/folders/{id}/move/", api.MoveFolder)
+		r.Delete("/api/folders/{id}/", api.DeleteFolder)
+
+		// Workspace related routes
+		r.Get("/api/workspaces/", api.GetWorkspaces)
+		r.Post("/api/workspaces/", api.CreateWorkspace)
+		r.Put("/api/workspaces/{id}/members/", api.SetWorkspaceMember)
+		r.Get("/api/workspaces/{id}/schema/", api.GetMetadataSchema)
+		r.Put("/api/workspaces/{id}/schema/", api.SetMetadataSchema)
+		r.Get("/api/workspaces/{id}/upload-policy/", api.GetWorkspaceUploadPolicy)
+		r.Put("/api/workspaces/{id}/upload-policy/", api.SetWorkspaceUploadPolicy)
+
+		// Upload policy routes
+		r.Get("/api/upload-policy/", api.GetUploadPolicy)
+		r.Put("/api/upload-policy/", api.SetUploadPolicy)
+		r.Get("/api/upload-policy/violations/", api.GetPolicyViolations)
+	})
 
 	log.Printf("Server is running on port %s", config.AppConfig.ServerPort)
 	log.Fatal(http.ListenAndServe(":"+config.AppConfig.ServerPort, r))
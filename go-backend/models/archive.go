@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// ArchiveListing is the cached table of contents of a stored archive. It is
+// keyed by the blob hash, so every file with the same content shares it.
+type ArchiveListing struct {
+	Hash      string         `bson:"_id" json:"-"`
+	Format    string         `bson:"format" json:"format"`
+	Entries   []ArchiveEntry `bson:"entries" json:"entries"`
+	Truncated bool           `bson:"truncated,omitempty" json:"truncated,omitempty"` // More entries than are listed
+	CreatedAt time.Time      `bson:"created_at" json:"-"`
+}
+
+// ArchiveEntry is one file or directory inside an archive.
+type ArchiveEntry struct {
+	Name     string    `bson:"name" json:"name"`
+	Size     int64     `bson:"size" json:"size"`
+	Modified time.Time `bson:"modified" json:"modified"`
+	Dir      bool      `bson:"dir,omitempty" json:"dir,omitempty"`
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // File represents the metadata for a file document in MongoDB.
 type File struct {
@@ -9,10 +12,92 @@ type File struct {
 	// The `json` tag tells the `encoding/json` package how to serialize this field
 	// for API responses.
 	ID               string    `bson:"_id" json:"id"`
-	File             string    `bson:"file" json:"file"` // Stores the path to the physical file
+	File             string    `bson:"file" json:"file"` // Stores the path to the physical file; clients get the download URL
 	OriginalFilename string    `bson:"original_filename" json:"original_filename"`
-	FileType         string    `bson:"file_type" json:"file_type"`
+	Description      string    `bson:"description" json:"description"`
+	WorkspaceID      string    `bson:"workspace_id" json:"workspace_id"` // Empty for the default workspace
+	FolderID         string    `bson:"folder_id" json:"folder_id"`       // Empty for files at the root
+	Owner            string    `bson:"owner" json:"owner"`               // Username of the uploader
+	Tags             []string  `bson:"tags,omitempty" json:"tags"`
+	FileType         string    `bson:"file_type" json:"file_type"`                             // Detected from the content
+	DeclaredType     string    `bson:"declared_type,omitempty" json:"declared_type,omitempty"` // As sent by the client
+	TypeMismatch     bool      `bson:"type_mismatch,omitempty" json:"type_mismatch,omitempty"` // The declared type contradicts the content
 	Size             int64     `bson:"size" json:"size"`
 	Hash             string    `bson:"hash" json:"hash"`
 	UploadedAt       time.Time `bson:"uploaded_at" json:"uploaded_at"`
-}
\ No newline at end of file
+	UpdatedAt        time.Time `bson:"updated_at" json:"updated_at"`
+	SourceURL        string    `bson:"source_url,omitempty" json:"source_url,omitempty"` // Where an imported file was fetched from
+
+	// Scan is the virus scan of the current content, nil if it was never
+	// scanned.
+	Scan *ScanResult `bson:"scan,omitempty" json:"scan,omitempty"`
+
+	// Version is the number of the current version. Versions is the content
+	// history, oldest first and including the current version; it is empty
+	// for files uploaded before versioning, which only have one version.
+	Version     int           `bson:"version,omitempty" json:"version,omitempty"`
+	MaxVersions int           `bson:"max_versions,omitempty" json:"max_versions,omitempty"` // 0 uses the global limit
+	Versions    []FileVersion `bson:"versions,omitempty" json:"-"`
+
+	// DeletedAt is set while the file is in the trash.
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy string     `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+
+	// Custom metadata values are strings, float64 numbers or time.Time dates.
+	Metadata map[string]interface{} `bson:"metadata,omitempty" json:"metadata,omitempty"`
+
+	// Content is the text extracted from the document for full-text search.
+	// It is never sent to clients; content searches return a Snippet instead.
+	Content string   `bson:"content,omitempty" json:"-"`
+	Score   float64  `bson:"score,omitempty" json:"score,omitempty"` // Relevance of a content search
+	Snippet *Snippet `bson:"-" json:"snippet,omitempty"`
+}
+
+// MarshalJSON sends the file's download URL in place of the path of the
+// physical file. Deduplicated files share a physical file, so the path would
+// tell an uploader that somebody else already stored the same content.
+func (f File) MarshalJSON() ([]byte, error) {
+	type file File // Drops this method so that Marshal does not recurse
+	out := file(f)
+	out.File = "/api/files/" + f.ID + "/download/"
+	return json.Marshal(out)
+}
+
+// FileVersion is one revision of a file's content. Versions with the same
+// content share a physical file through the hash deduplication.
+type FileVersion struct {
+	Version      int         `bson:"version" json:"version"`
+	File         string      `bson:"file" json:"-"` // Path to the physical file
+	Hash         string      `bson:"hash" json:"hash"`
+	Size         int64       `bson:"size" json:"size"`
+	FileType     string      `bson:"file_type" json:"file_type"`
+	DeclaredType string      `bson:"declared_type,omitempty" json:"declared_type,omitempty"`
+	TypeMismatch bool        `bson:"type_mismatch,omitempty" json:"type_mismatch,omitempty"`
+	Scan         *ScanResult `bson:"scan,omitempty" json:"scan,omitempty"`
+	UploadedBy   string      `bson:"uploaded_by" json:"uploaded_by"`
+	UploadedAt   time.Time   `bson:"uploaded_at" json:"uploaded_at"`
+}
+
+// Virus scan statuses.
+const (
+	ScanPending  = "pending" // Quarantined until the background scan is done
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	ScanFailed   = "error" // The scanner could not reach a verdict
+)
+
+// ScanResult is the outcome of the virus scan of a file's content. Files
+// and versions that share a physical file share its result.
+type ScanResult struct {
+	Status     string     `bson:"status" json:"status"`
+	Threat     string     `bson:"threat,omitempty" json:"threat,omitempty"`         // Name of the signature that matched
+	Signatures string     `bson:"signatures,omitempty" json:"signatures,omitempty"` // Version of the signature database used
+	ScannedAt  *time.Time `bson:"scanned_at,omitempty" json:"scanned_at,omitempty"`
+}
+
+// Snippet is an excerpt of a file's content around the words of a content
+// search. Highlights are [start, end) byte offsets of the matches in Text.
+type Snippet struct {
+	Text       string   `json:"text"`
+	Highlights [][2]int `json:"highlights"`
+}
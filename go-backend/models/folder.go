@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Folder groups files and other folders into a tree. A folder with an empty
+// ParentID sits at the root.
+type Folder struct {
+	ID          string    `bson:"_id" json:"id"`
+	Name        string    `bson:"name" json:"name"`
+	ParentID    string    `bson:"parent_id" json:"parent_id"`
+	WorkspaceID string    `bson:"workspace_id" json:"workspace_id"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// Breadcrumb is one step on the path from the root to a folder.
+type Breadcrumb struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Job statuses.
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a long-running operation whose progress clients poll.
+type Job struct {
+	ID         string      `bson:"_id" json:"id"`
+	Owner      string      `bson:"owner" json:"owner"`
+	Operation  string      `bson:"operation" json:"operation"`
+	Status     string      `bson:"status" json:"status"`
+	Total      int         `bson:"total" json:"total"`
+	Done       int         `bson:"done" json:"done"`
+	Failed     int         `bson:"failed" json:"failed"`
+	Results    []JobResult `bson:"results" json:"results"`
+	Error      string      `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt  time.Time   `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time   `bson:"updated_at" json:"updated_at"`
+	FinishedAt *time.Time  `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
+
+// JobResult is the outcome of a job for one item. Status is the HTTP status
+// the item would have had as a single request.
+type JobResult struct {
+	ID     string `bson:"id" json:"id"`
+	Status int    `bson:"status" json:"status"`
+	Error  string `bson:"error,omitempty" json:"error,omitempty"`
+	FileID string `bson:"file_id,omitempty" json:"file_id,omitempty"` // Set when the item created a new file
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Types a custom metadata field can have.
+const (
+	MetadataString = "string"
+	MetadataNumber = "number"
+	MetadataDate   = "date"
+	MetadataEnum   = "enum"
+)
+
+// MetadataSchema gives types and required flags to the custom metadata
+// fields of a workspace. Keys that are not in the schema are stored as
+// plain strings.
+type MetadataSchema struct {
+	WorkspaceID string          `bson:"_id" json:"workspace_id"`
+	Fields      []MetadataField `bson:"fields" json:"fields"`
+	UpdatedAt   time.Time       `bson:"updated_at" json:"updated_at"`
+}
+
+// MetadataField describes a single custom metadata field.
+type MetadataField struct {
+	Name     string   `bson:"name" json:"name"`
+	Type     string   `bson:"type" json:"type"`
+	Required bool     `bson:"required" json:"required"`
+	Values   []string `bson:"values,omitempty" json:"values,omitempty"` // Allowed values of an enum
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// GlobalPolicyID is the ID of the upload policy that applies to every
+// workspace. Other policies have the ID of their workspace.
+const GlobalPolicyID = "global"
+
+// UploadPolicy restricts which files can be stored. A file must not match
+// any deny rule and, where allow rules are given, must match one of them.
+// Types are detected MIME types, exact or with a wildcard subtype such as
+// "image/*". Extensions include the dot, e.g. ".exe". Patterns match the
+// whole filename, with '*' matching any run of characters. All comparisons
+// ignore case.
+type UploadPolicy struct {
+	ID              string    `bson:"_id" json:"id"`
+	AllowTypes      []string  `bson:"allow_types" json:"allow_types"`
+	DenyTypes       []string  `bson:"deny_types" json:"deny_types"`
+	AllowExtensions []string  `bson:"allow_extensions" json:"allow_extensions"`
+	DenyExtensions  []string  `bson:"deny_extensions" json:"deny_extensions"`
+	DenyPatterns    []string  `bson:"deny_patterns" json:"deny_patterns"`
+	UpdatedAt       time.Time `bson:"updated_at" json:"updated_at"`
+	UpdatedBy       string    `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Workspace roles, from most to least privileged.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Workspace is a shared space for files and folders. Files and folders with
+// an empty WorkspaceID belong to the default workspace that every user can
+// read and write.
+type Workspace struct {
+	ID        string            `bson:"_id" json:"id"`
+	Name      string            `bson:"name" json:"name"`
+	Owner     string            `bson:"owner" json:"owner"`
+	Members   []WorkspaceMember `bson:"members" json:"members"`
+	CreatedAt time.Time         `bson:"created_at" json:"created_at"`
+}
+
+// WorkspaceMember grants a user a role in a workspace.
+type WorkspaceMember struct {
+	Username string `bson:"username" json:"username"`
+	Role     string `bson:"role" json:"role"`
+}
@@ -0,0 +1,192 @@
+// Package scan checks content for malware. Scanners are reached over the
+// network; the clamd implementation speaks the INSTREAM protocol of the
+// ClamAV daemon, so any server that speaks it, including a fake one for
+// testing, can be used.
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Result is the verdict on scanned content.
+type Result struct {
+	Infected bool
+	Threat   string // Name of the signature that matched
+}
+
+// Scanner checks content for malware.
+type Scanner interface {
+	// Scan reads r to the end and reports whether it is infected. An error
+	// means no verdict could be reached.
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+	// Signatures returns the version of the signature database, which
+	// changes whenever the signatures are updated.
+	Signatures(ctx context.Context) (string, error)
+}
+
+// ErrSizeLimit is returned when the content is larger than the scanner
+// accepts.
+var ErrSizeLimit = errors.New("content exceeds the scanner's size limit")
+
+// chunkSize is the size of the chunks content is streamed in. clamd reads
+// chunks of any size up to its StreamMaxLength.
+const chunkSize = 64 * 1024
+
+// Clamd is a Scanner backed by a ClamAV daemon.
+type Clamd struct {
+	Network string // "tcp" or "unix"
+	Address string
+	Timeout time.Duration // For a whole command, including the upload
+}
+
+// NewClamd returns a scanner for the clamd at address, given as
+// tcp://host:port, unix:///path/to/clamd.sock or plain host:port.
+func NewClamd(address string, timeout time.Duration) (*Clamd, error) {
+	if !strings.Contains(address, "://") {
+		return &Clamd{Network: "tcp", Address: address, Timeout: timeout}, nil
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp":
+		return &Clamd{Network: "tcp", Address: u.Host, Timeout: timeout}, nil
+	case "unix":
+		return &Clamd{Network: "unix", Address: u.Path, Timeout: timeout}, nil
+	}
+	return nil, fmt.Errorf("unsupported clamd address scheme %q", u.Scheme)
+}
+
+// command sends a null-terminated command and, once send has written any
+// payload, reads the reply.
+func (c *Clamd) command(ctx context.Context, name string, send func(io.Writer) error) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	var deadline time.Time
+	if c.Timeout > 0 {
+		deadline = time.Now().Add(c.Timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	if !deadline.IsZero() {
+		conn.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriterSize(conn, chunkSize+4)
+	_, sendErr := w.WriteString("z" + name + "\x00")
+	if sendErr == nil && send != nil {
+		sendErr = send(w)
+	}
+	if sendErr == nil {
+		sendErr = w.Flush()
+	}
+	// clamd answers and hangs up when it rejects a command part way, for
+	// example when a stream exceeds StreamMaxLength, so a failed write
+	// still leaves its reply to read. Other errors, such as failing to
+	// read the content, leave clamd waiting for more.
+	var opErr *net.OpError
+	if sendErr != nil && !errors.As(sendErr, &opErr) {
+		return "", sendErr
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(err == io.EOF && reply != "") {
+		if sendErr != nil {
+			return "", sendErr
+		}
+		return "", fmt.Errorf("reading clamd reply: %w", err)
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
+
+// Scan streams r to clamd with the INSTREAM command: chunks prefixed with
+// their length as a 4-byte big-endian integer, ended by an empty chunk.
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	reply, err := c.command(ctx, "INSTREAM", func(w io.Writer) error {
+		buf := make([]byte, chunkSize)
+		var size [4]byte
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				binary.BigEndian.PutUint32(size[:], uint32(n))
+				if _, err := w.Write(size[:]); err != nil {
+					return err
+				}
+				if _, err := w.Write(buf[:n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		binary.BigEndian.PutUint32(size[:], 0)
+		_, err := w.Write(size[:])
+		return err
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return parseReply(reply)
+}
+
+// parseReply reads a scan reply such as "stream: OK" or
+// "stream: Win.Test.EICAR_HDB-1 FOUND".
+func parseReply(reply string) (Result, error) {
+	_, verdict, _ := strings.Cut(reply, ": ")
+	switch {
+	case verdict == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return Result{Infected: true, Threat: strings.TrimSuffix(verdict, " FOUND")}, nil
+	case strings.Contains(reply, "size limit exceeded"):
+		return Result{}, ErrSizeLimit
+	}
+	return Result{}, fmt.Errorf("clamd: %s", reply)
+}
+
+// Signatures asks clamd for its version, e.g.
+// "ClamAV 1.3.1/27412/Thu Sep 26 08:35:41 2024", and returns the signature
+// database part, "27412/Thu Sep 26 08:35:41 2024".
+func (c *Clamd) Signatures(ctx context.Context) (string, error) {
+	reply, err := c.command(ctx, "VERSION", nil)
+	if err != nil {
+		return "", err
+	}
+	_, signatures, ok := strings.Cut(reply, "/")
+	if !ok {
+		// Without a loaded database clamd only reports its own version.
+		return "", fmt.Errorf("clamd did not report a signature version: %s", reply)
+	}
+	return signatures, nil
+}
+
+// Ping checks that clamd is up.
+func (c *Clamd) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "PING", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected reply to PING: %s", reply)
+	}
+	return nil
+}
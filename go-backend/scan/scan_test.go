@@ -0,0 +1,169 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// fakeClamd is a clamd that checks the framing of what it is sent. Streams
+// containing "EICAR" are infected, and streams over maxStream bytes are
+// rejected the way clamd rejects streams over StreamMaxLength: it answers
+// and hangs up without reading the rest.
+type fakeClamd struct {
+	t         *testing.T
+	maxStream int
+	streams   chan []byte // Every complete stream received
+}
+
+func startFakeClamd(t *testing.T, maxStream int) (*Clamd, *fakeClamd) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	fake := &fakeClamd{t: t, maxStream: maxStream, streams: make(chan []byte, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go fake.serve(conn)
+		}
+	}()
+	clamd, err := NewClamd("tcp://"+l.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clamd, fake
+}
+
+func (f *fakeClamd) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	switch command {
+	case "zPING\x00":
+		io.WriteString(conn, "PONG\x00")
+	case "zVERSION\x00":
+		io.WriteString(conn, "ClamAV 1.3.1/27412/Thu Sep 26 08:35:41 2024\x00")
+	case "zINSTREAM\x00":
+		var stream []byte
+		for {
+			var size [4]byte
+			if _, err := io.ReadFull(r, size[:]); err != nil {
+				return // The client gave up
+			}
+			n := binary.BigEndian.Uint32(size[:])
+			if n == 0 {
+				break
+			}
+			if n > chunkSize {
+				f.t.Errorf("chunk of %d bytes, want at most %d", n, chunkSize)
+			}
+			if len(stream)+int(n) > f.maxStream {
+				io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+				return
+			}
+			chunk := make([]byte, n)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			stream = append(stream, chunk...)
+		}
+		f.streams <- stream
+		if bytes.Contains(stream, []byte("EICAR")) {
+			io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+		} else {
+			io.WriteString(conn, "stream: OK\x00")
+		}
+	default:
+		f.t.Errorf("unexpected command %q", command)
+		io.WriteString(conn, "UNKNOWN COMMAND\x00")
+	}
+}
+
+func TestClamdScanClean(t *testing.T) {
+	clamd, fake := startFakeClamd(t, 1<<20)
+	// More than one chunk, and not a multiple of the chunk size.
+	content := bytes.Repeat([]byte("clean "), 30000)
+	result, err := clamd.Scan(context.Background(), bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Infected {
+		t.Errorf("result = %+v, want clean", result)
+	}
+	if got := <-fake.streams; !bytes.Equal(got, content) {
+		t.Errorf("clamd received %d bytes, want the %d sent", len(got), len(content))
+	}
+}
+
+func TestClamdScanEmpty(t *testing.T) {
+	clamd, fake := startFakeClamd(t, 1<<20)
+	if _, err := clamd.Scan(context.Background(), strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-fake.streams; len(got) != 0 {
+		t.Errorf("clamd received %d bytes, want none", len(got))
+	}
+}
+
+func TestClamdScanInfected(t *testing.T) {
+	clamd, _ := startFakeClamd(t, 1<<20)
+	result, err := clamd.Scan(context.Background(), strings.NewReader("X5O!P%@AP EICAR test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Infected || result.Threat != "Eicar-Test-Signature" {
+		t.Errorf("result = %+v, want infected with Eicar-Test-Signature", result)
+	}
+}
+
+func TestClamdScanSizeLimit(t *testing.T) {
+	clamd, _ := startFakeClamd(t, 1024)
+	for _, size := range []int{2048, 16 << 20} {
+		// The large stream outgrows the socket buffers, so writing fails
+		// once clamd hangs up and the reply must be read regardless.
+		_, err := clamd.Scan(context.Background(), bytes.NewReader(make([]byte, size)))
+		if !errors.Is(err, ErrSizeLimit) {
+			t.Errorf("scanning %d bytes: err = %v, want ErrSizeLimit", size, err)
+		}
+	}
+}
+
+func TestClamdSignaturesAndPing(t *testing.T) {
+	clamd, _ := startFakeClamd(t, 1024)
+	signatures, err := clamd.Signatures(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "27412/Thu Sep 26 08:35:41 2024"; signatures != want {
+		t.Errorf("signatures = %q, want %q", signatures, want)
+	}
+	if err := clamd.Ping(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClamdReadError(t *testing.T) {
+	clamd, _ := startFakeClamd(t, 1<<20)
+	failure := errors.New("disk on fire")
+	_, err := clamd.Scan(context.Background(), io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(failure)))
+	if !errors.Is(err, failure) {
+		t.Errorf("err = %v, want %v", err, failure)
+	}
+}
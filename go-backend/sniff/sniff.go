@@ -0,0 +1,334 @@
+// Package sniff detects the type of a file from its leading bytes rather
+// than from the name or the Content-Type a client claims. Detection is table
+// driven: each Signature matches magic bytes at an offset, and container
+// formats such as ZIP refine the match by looking a little deeper.
+package sniff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// HeaderSize is how many leading bytes Detect needs to see.
+const HeaderSize = 8192
+
+// Octet is the type of content that could not be identified.
+const Octet = "application/octet-stream"
+
+// Signature identifies a format by magic bytes at a fixed offset.
+type Signature struct {
+	Offset int
+	Magic  []byte
+	MIME   string
+	// Refine, if set, narrows down a match, e.g. a ZIP file to the document
+	// format stored in it. It returns "" to keep MIME.
+	Refine func(header []byte, filename string) string
+}
+
+var (
+	mu         sync.RWMutex
+	signatures = []Signature{
+		// Documents
+		{Magic: []byte("%PDF-"), MIME: "application/pdf"},
+		{Magic: []byte("{\\rtf"), MIME: "application/rtf"},
+		{Magic: []byte("%!PS"), MIME: "application/postscript"},
+		{Magic: []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"), MIME: "application/x-ole-storage", Refine: refineOLE},
+		{Magic: []byte("SQLite format 3\x00"), MIME: "application/vnd.sqlite3"},
+
+		// Images
+		{Magic: []byte("\x89PNG\r\n\x1A\n"), MIME: "image/png"},
+		{Magic: []byte("\xFF\xD8\xFF"), MIME: "image/jpeg"},
+		{Magic: []byte("GIF87a"), MIME: "image/gif"},
+		{Magic: []byte("GIF89a"), MIME: "image/gif"},
+		{Magic: []byte("RIFF"), MIME: Octet, Refine: refineRIFF},
+		{Magic: []byte("II*\x00"), MIME: "image/tiff"},
+		{Magic: []byte("MM\x00*"), MIME: "image/tiff"},
+		{Magic: []byte("BM"), MIME: "image/bmp", Refine: refineBMP},
+		{Magic: []byte("\x00\x00\x01\x00"), MIME: "image/vnd.microsoft.icon"},
+		{Magic: []byte("8BPS"), MIME: "image/vnd.adobe.photoshop"},
+
+		// Audio and video
+		{Offset: 4, Magic: []byte("ftyp"), MIME: "video/mp4", Refine: refineFtyp},
+		{Magic: []byte("ID3"), MIME: "audio/mpeg"},
+		{Magic: []byte("\xFF\xFB"), MIME: "audio/mpeg"},
+		{Magic: []byte("\xFF\xF3"), MIME: "audio/mpeg"},
+		{Magic: []byte("\xFF\xF2"), MIME: "audio/mpeg"},
+		{Magic: []byte("OggS"), MIME: "audio/ogg"},
+		{Magic: []byte("fLaC"), MIME: "audio/flac"},
+		{Magic: []byte("\x1A\x45\xDF\xA3"), MIME: "video/x-matroska", Refine: refineMatroska},
+
+		// Archives
+		{Magic: []byte("PK\x03\x04"), MIME: "application/zip", Refine: refineZip},
+		{Magic: []byte("PK\x05\x06"), MIME: "application/zip"},
+		{Magic: []byte("\x1F\x8B"), MIME: "application/gzip"},
+		{Magic: []byte("BZh"), MIME: "application/x-bzip2"},
+		{Magic: []byte("\xFD7zXZ\x00"), MIME: "application/x-xz"},
+		{Magic: []byte("7z\xBC\xAF\x27\x1C"), MIME: "application/x-7z-compressed"},
+		{Magic: []byte("Rar!\x1A\x07"), MIME: "application/vnd.rar"},
+		{Offset: 257, Magic: []byte("ustar"), MIME: "application/x-tar"},
+
+		// Executables and scripts
+		{Magic: []byte("MZ"), MIME: "application/vnd.microsoft.portable-executable"},
+		{Magic: []byte("\x7FELF"), MIME: "application/x-executable"},
+		{Magic: []byte("\xFE\xED\xFA\xCE"), MIME: "application/x-mach-binary"},
+		{Magic: []byte("\xFE\xED\xFA\xCF"), MIME: "application/x-mach-binary"},
+		{Magic: []byte("\xCE\xFA\xED\xFE"), MIME: "application/x-mach-binary"},
+		{Magic: []byte("\xCF\xFA\xED\xFE"), MIME: "application/x-mach-binary"},
+		{Magic: []byte("\x00asm"), MIME: "application/wasm"},
+		{Magic: []byte("#!"), MIME: "text/x-shellscript"},
+	}
+)
+
+// Register adds a signature. Signatures are tried in the order they were
+// added, so a registered signature only applies to content that none of the
+// built-in ones match.
+func Register(sig Signature) {
+	mu.Lock()
+	defer mu.Unlock()
+	signatures = append(signatures, sig)
+}
+
+// Detect returns the MIME type of content from its first bytes (at least
+// HeaderSize of them, if the content is that long) and its filename. It
+// returns Octet if the type cannot be told.
+func Detect(header []byte, filename string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, sig := range signatures {
+		if len(header) < sig.Offset+len(sig.Magic) || !bytes.Equal(header[sig.Offset:sig.Offset+len(sig.Magic)], sig.Magic) {
+			continue
+		}
+		if sig.Refine != nil {
+			if refined := sig.Refine(header, filename); refined != "" {
+				return refined
+			}
+		}
+		if sig.MIME != Octet {
+			return sig.MIME
+		}
+	}
+	return detectText(header, filename)
+}
+
+// detectText tells apart the text formats, which have no magic bytes.
+func detectText(header []byte, filename string) string {
+	trimmed := bytes.TrimLeft(header, "\xEF\xBB\xBF \t\r\n")
+	if bytes.HasPrefix(trimmed, []byte("<?xml")) || bytes.HasPrefix(trimmed, []byte("<svg")) {
+		if bytes.Contains(header, []byte("<svg")) {
+			return "image/svg+xml"
+		}
+	}
+	if detected := Normalize(http.DetectContentType(header)); detected != "text/plain" {
+		return detected
+	}
+	// Plain text can be any of the text formats; the extension is the best
+	// guide to which.
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return "text/csv"
+	case ".md", ".markdown":
+		return "text/markdown"
+	case ".json":
+		return "application/json"
+	case ".js", ".mjs":
+		return "text/javascript"
+	case ".sh", ".bash":
+		return "text/x-shellscript"
+	case ".bat", ".cmd", ".ps1", ".vbs":
+		return "text/x-script"
+	}
+	return "text/plain"
+}
+
+// zipFormats are document formats stored as ZIP files, by the directory
+// their main part lives in.
+var zipFormats = []struct {
+	entry string
+	mime  string
+}{
+	{"word/", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{"xl/", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{"ppt/", "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	{"META-INF/MANIFEST.MF", "application/java-archive"},
+	{"AndroidManifest.xml", "application/vnd.android.package-archive"},
+}
+
+// zipByExtension covers ZIP-based formats whose telling entries may come
+// after the first HeaderSize bytes.
+var zipByExtension = map[string]string{
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".jar":  "application/java-archive",
+	".apk":  "application/vnd.android.package-archive",
+}
+
+func refineZip(header []byte, filename string) string {
+	// OpenDocument and EPUB files start with an uncompressed "mimetype"
+	// entry holding their type.
+	const nameAt = 30 // Offset of the first entry's name
+	if len(header) > nameAt && bytes.HasPrefix(header[nameAt:], []byte("mimetypeapplication/")) {
+		start := nameAt + len("mimetype")
+		end := start + int(binary.LittleEndian.Uint32(header[18:22])) // Compressed size, which is the size
+		if end == start {
+			// The size is in a data descriptor after the content.
+			end = start + bytes.Index(header[start:], []byte("PK"))
+		}
+		if end > start && end <= len(header) && end-start <= 100 {
+			return string(header[start:end])
+		}
+	}
+	for _, format := range zipFormats {
+		if bytes.Contains(header, []byte(format.entry)) {
+			return format.mime
+		}
+	}
+	return zipByExtension[strings.ToLower(filepath.Ext(filename))]
+}
+
+func refineOLE(_ []byte, filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".doc", ".dot":
+		return "application/msword"
+	case ".xls", ".xlt":
+		return "application/vnd.ms-excel"
+	case ".ppt", ".pot", ".pps":
+		return "application/vnd.ms-powerpoint"
+	case ".msg":
+		return "application/vnd.ms-outlook"
+	case ".msi":
+		return "application/x-msi"
+	}
+	return ""
+}
+
+func refineRIFF(header []byte, _ string) string {
+	if len(header) < 12 {
+		return ""
+	}
+	switch string(header[8:12]) {
+	case "WEBP":
+		return "image/webp"
+	case "WAVE":
+		return "audio/wav"
+	case "AVI ":
+		return "video/x-msvideo"
+	}
+	return ""
+}
+
+// refineBMP checks the reserved header fields, which are zero, since "BM"
+// alone also starts plenty of text.
+func refineBMP(header []byte, filename string) string {
+	if len(header) >= 14 && bytes.Equal(header[6:10], []byte{0, 0, 0, 0}) {
+		return ""
+	}
+	return detectText(header, filename)
+}
+
+func refineFtyp(header []byte, _ string) string {
+	if len(header) < 12 {
+		return ""
+	}
+	switch string(header[8:12]) {
+	case "heic", "heix", "heim", "heis", "mif1", "msf1":
+		return "image/heic"
+	case "avif", "avis":
+		return "image/avif"
+	case "qt  ":
+		return "video/quicktime"
+	case "M4A ", "M4B ":
+		return "audio/mp4"
+	case "3gp4", "3gp5", "3gp6", "3ge6", "3gg6":
+		return "video/3gpp"
+	}
+	return ""
+}
+
+func refineMatroska(header []byte, _ string) string {
+	if bytes.Contains(header, []byte("webm")) {
+		return "video/webm"
+	}
+	return ""
+}
+
+// generic are declared types that say nothing about the content.
+var generic = map[string]bool{
+	"":                           true,
+	Octet:                        true,
+	"binary/octet-stream":        true,
+	"application/unknown":        true,
+	"application/x-download":     true,
+	"application/force-download": true,
+}
+
+// aliases maps non-standard names that clients send to the name Detect uses.
+var aliases = map[string]string{
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/x-png":                  "image/png",
+	"image/x-icon":                 "image/vnd.microsoft.icon",
+	"image/x-ms-bmp":               "image/bmp",
+	"application/x-pdf":            "application/pdf",
+	"application/x-zip-compressed": "application/zip",
+	"application/x-zip":            "application/zip",
+	"application/x-gzip":           "application/gzip",
+	"application/x-rar-compressed": "application/vnd.rar",
+	"application/x-rar":            "application/vnd.rar",
+	"application/x-msdownload":     "application/vnd.microsoft.portable-executable",
+	"application/x-dosexec":        "application/vnd.microsoft.portable-executable",
+	"application/x-sh":             "text/x-shellscript",
+	"application/javascript":       "text/javascript",
+	"application/x-javascript":     "text/javascript",
+	"text/xml":                     "application/xml",
+	"audio/mp3":                    "audio/mpeg",
+	"audio/x-wav":                  "audio/wav",
+	"audio/wave":                   "audio/wav",
+	"audio/x-flac":                 "audio/flac",
+	"audio/x-m4a":                  "audio/mp4",
+	"video/avi":                    "video/x-msvideo",
+	"text/rtf":                     "application/rtf",
+}
+
+// Normalize lowercases a media type, drops its parameters and maps aliases
+// to the name Detect uses.
+func Normalize(mediaType string) string {
+	mediaType = strings.ToLower(strings.TrimSpace(strings.Split(mediaType, ";")[0]))
+	if alias, ok := aliases[mediaType]; ok {
+		return alias
+	}
+	return mediaType
+}
+
+// Mismatch reports whether a declared type contradicts the detected one.
+// Generic declarations such as application/octet-stream never do, and
+// neither do declarations that detection cannot tell apart from the
+// detected type, such as a specific text format for plain text or a
+// ZIP-based format for a ZIP file.
+func Mismatch(declared, detected string) bool {
+	declared, detected = Normalize(declared), Normalize(detected)
+	if generic[declared] || detected == Octet || declared == detected {
+		return false
+	}
+	switch detected {
+	case "text/plain":
+		return !isText(declared)
+	case "application/zip":
+		return !strings.Contains(declared, "zip") && !strings.Contains(declared, "openxmlformats") &&
+			!strings.Contains(declared, "opendocument") && declared != "application/epub+zip" && declared != "application/java-archive"
+	case "application/x-ole-storage":
+		return !strings.HasPrefix(declared, "application/vnd.ms-") && declared != "application/msword"
+	case "application/xml":
+		return !strings.HasSuffix(declared, "+xml") && !isText(declared)
+	}
+	return true
+}
+
+func isText(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" ||
+		mediaType == "application/xml" || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}